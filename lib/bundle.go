@@ -1,12 +1,14 @@
 package icepacker
 
 import (
+	"bytes"
 	"crypto/sha512"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
 	"path/filepath"
+	"sync"
 )
 
 // BundleSettings records the settings of the bundle file
@@ -14,37 +16,199 @@ type BundleSettings struct {
 	Compression byte
 	Encryption  byte
 	CipherKey   []byte
+	Level       int
+	Rules       []CompressRule
+	Solid       bool
+	Dictionary  []byte
+
+	// WindowSize is the long-range window of the Zstandard compression
+	// (0 means the default window of the level). See NewZstdCompressor.
+	WindowSize int
+}
+
+// CompressionOf returns the compression of the file by the rules.
+// If no rule matches, returns the Compression of the bundle.
+func (this BundleSettings) CompressionOf(path string) byte {
+	for _, rule := range this.Rules {
+		if rule.Match(path) {
+			return rule.Compression
+		}
+	}
+	return this.Compression
+}
+
+// check checks the codecs are registered and the level is valid for the
+// used compressions
+func (this BundleSettings) check() error {
+	if _, err := GetCipher(this.Encryption); err != nil {
+		return err
+	}
+
+	if this.WindowSize != 0 {
+		if _, err := NewZstdCompressor(this.WindowSize); err != nil {
+			return err
+		}
+	}
+
+	if len(this.Dictionary) > 0 {
+		if err := this.checkDictionary(); err != nil {
+			return err
+		}
+	}
+
+	compressions := []byte{this.Compression}
+	for _, rule := range this.Rules {
+		compressions = append(compressions, rule.Compression)
+	}
+
+	for _, compression := range compressions {
+		compressor, err := GetCompressor(compression)
+		if err != nil {
+			return err
+		}
+		if this.Level < LEVEL_DEFAULT || (compressor.MaxLevel() > 0 && this.Level > compressor.MaxLevel()) {
+			return fmt.Errorf("Invalid compression level (%d)!", this.Level)
+		}
+	}
+	return nil
 }
 
-// BundleFile contains all info from bundle
+// checkDictionary checks that the compression of the bundle and of the rules
+// support the shared dictionary, so no file is compressed without it silently.
+func (this *BundleSettings) checkDictionary() error {
+	compressions := []byte{this.Compression}
+	for _, rule := range this.Rules {
+		if rule.Compression != COMPRESS_NONE {
+			compressions = append(compressions, rule.Compression)
+		}
+	}
+
+	for _, compression := range compressions {
+		compressor, err := GetCompressor(compression)
+		if err != nil {
+			return err
+		}
+		if _, ok := compressor.(DictCompressor); !ok {
+			return fmt.Errorf("The %s compression doesn't support dictionary!", compressor.Name())
+		}
+	}
+	return nil
+}
+
+// BundleFile contains all info from bundle.
+//
+// The reading methods (ReadFile, ReadFileFromPath, GetItemByPath) use
+// positional reads, so they can be called concurrently from many goroutines
+// on the same opened bundle. The modifying methods (AddFile, FindDuplicate,
+// Finalize, Close) must not be called concurrently with any other method.
+//
+// If the Cache is set, the decoded contents are cached by ReadFile. The same
+// Cache can be set on more bundles.
 type BundleFile struct {
+	id             uint64
 	Path           string
 	File           *os.File
+	reader         io.ReaderAt
+	writer         io.WriterAt
+	closer         io.Closer
 	FAT            FAT
 	Header         *Header
 	Footer         *Footer
 	DataBaseOffset int64
 	Settings       BundleSettings
+	Cache          *ContentCache
 	DupCount       int
 	DupSize        int64
 	edited         bool
+	zstd           *ZstdCompressor
+	dupIndex       map[dupKey]int
+	dupSizes       map[int64]bool
+	dupIndexed     int
+	segment        *solidSegment
+	segmentCache   segmentCache
+	mapped         []byte
+	index          *FATIndex
+	fatOffset      int64
+	pageCache      pageCache
+	lazy           bool
+	paths          pathIndex
+}
+
+// solidSegment records the content & the FAT indexes of the files of the
+// solid segment which is not written yet
+type solidSegment struct {
+	content bytes.Buffer
+	items   []int
+}
+
+// segmentCache caches the last decoded solid segment, because the files of
+// a segment are usually read one after the other
+type segmentCache struct {
+	lock    sync.Mutex
+	offset  int64
+	content []byte
+}
+
+// dupKey is the key of the duplication index
+type dupKey struct {
+	Hash     [64]byte
+	OrigSize int64
 }
 
 // FindDuplication finds the duplicated file contents by hash of content.
 func (this *BundleFile) FindDuplicate(newItem *FATItem) *FATItem {
-	for _, item := range this.FAT.Items {
-		if newItem.Hash == item.Hash && newItem.OrigSize == item.OrigSize {
-			return &item
-		}
+	if i := this.findDuplicate(newItem); i >= 0 {
+		item := this.FAT.Items[i]
+		return &item
 	}
 	return nil
 }
 
+// findDuplicate returns the index of the first FAT item with the same
+// content. Returns -1 if the content is not duplicated.
+func (this *BundleFile) findDuplicate(newItem *FATItem) int {
+	this.indexDuplicates()
+	if i, found := this.dupIndex[dupKey{newItem.Hash, newItem.OrigSize}]; found {
+		return i
+	}
+	return -1
+}
+
+// hasSize returns true if the bundle contains a file with the size, so a
+// file with this size may be duplicated
+func (this *BundleFile) hasSize(size int64) bool {
+	this.indexDuplicates()
+	return this.dupSizes[size]
+}
+
+// indexDuplicates adds the new items of the FAT to the duplication index
+func (this *BundleFile) indexDuplicates() {
+	if this.dupIndex == nil {
+		this.dupIndex = make(map[dupKey]int)
+		this.dupSizes = make(map[int64]bool)
+	}
+
+	for ; this.dupIndexed < len(this.FAT.Items); this.dupIndexed++ {
+		item := this.FAT.Items[this.dupIndexed]
+		key := dupKey{item.Hash, item.OrigSize}
+		if _, found := this.dupIndex[key]; !found {
+			this.dupIndex[key] = this.dupIndexed
+		}
+		this.dupSizes[item.OrigSize] = true
+	}
+}
+
 // CreateBundle created a new bundle file & struct.
 func CreateBundle(filename string, settings BundleSettings) (*BundleFile, error) {
 
+	// Check the codecs & the compression level
+	err := settings.check()
+	if err != nil {
+		return nil, err
+	}
+
 	// Create folders for target file
-	err := os.MkdirAll(filepath.Dir(filename), DEFAULT_PERMISSION)
+	err = os.MkdirAll(filepath.Dir(filename), DEFAULT_PERMISSION)
 	if err != nil {
 		return nil, err
 	}
@@ -55,11 +219,31 @@ func CreateBundle(filename string, settings BundleSettings) (*BundleFile, error)
 		return nil, err
 	}
 
+	bundle, err := newBundle(f, f, settings)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	bundle.Path = filename
+	bundle.File = f
+	return bundle, nil
+}
+
+// newBundle creates a new bundle struct and writes the header & the
+// dictionary with the writer
+func newBundle(writer io.WriterAt, reader io.ReaderAt, settings BundleSettings) (*BundleFile, error) {
+
 	// Create FAT
 	fat := FAT{Count: 0, Size: 0}
 
 	// Creat new Bundle
-	bundle := BundleFile{Path: filename, File: f, FAT: fat, Settings: settings, edited: true}
+	bundle := BundleFile{id: nextBundleID(), reader: reader, writer: writer, FAT: fat, Settings: settings, edited: true}
+
+	// The Zstandard compressor with the long-range window
+	if settings.WindowSize > 0 {
+		bundle.zstd = &ZstdCompressor{WindowSize: settings.WindowSize}
+	}
 
 	// Create a new header
 	bundle.Header = NewHeader(settings.Encryption, settings.Compression)
@@ -70,7 +254,12 @@ func CreateBundle(filename string, settings BundleSettings) (*BundleFile, error)
 	// Create a new footer
 	bundle.Footer = NewFooter()
 
-	err = bundle.Header.Write(bundle.File)
+	err := bundle.writeHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	err = bundle.writeDictionary(settings.Dictionary)
 	if err != nil {
 		return nil, err
 	}
@@ -78,11 +267,78 @@ func CreateBundle(filename string, settings BundleSettings) (*BundleFile, error)
 	return &bundle, nil
 }
 
+// compressor returns the registered compressor by ID for the packing. The
+// Zstandard compression uses the long-range window of the settings, if it's set.
+func (this *BundleFile) compressor(id byte) (Compressor, error) {
+	if id == COMPRESS_ZSTD && this.zstd != nil {
+		return this.zstd, nil
+	}
+	return GetCompressor(id)
+}
+
+// writeHeader writes the header to the begin of the bundle
+func (this *BundleFile) writeHeader() error {
+	buf := new(bytes.Buffer)
+	err := this.Header.Write(buf)
+	if err != nil {
+		return err
+	}
+
+	_, err = this.writer.WriteAt(buf.Bytes(), this.DataBaseOffset-HEADER_SIZE)
+	return err
+}
+
+// writeDictionary sets & writes the shared dictionary (compressed by the
+// compression of the bundle) to the begin of the data block. It must be
+// called before adding files.
+func (this *BundleFile) writeDictionary(dict []byte) error {
+	if len(dict) == 0 {
+		return nil
+	}
+	if this.FAT.Size > 0 {
+		return errors.New("The dictionary must be written before the files!")
+	}
+
+	this.Settings.Dictionary = dict
+	err := this.Settings.check()
+	if err != nil {
+		return err
+	}
+
+	blob, err := transformPackLevel(dict, this.Settings.Compression, this.Settings.Level, this.Settings.Encryption, this.Settings.CipherKey)
+	if err != nil {
+		return err
+	}
+
+	err = this.writeBlob(blob)
+	if err != nil {
+		return err
+	}
+
+	this.FAT.DictOffset = 0
+	this.FAT.DictSize = int64(len(blob))
+	return nil
+}
+
 // OpenBundle open an exist bundle file. Load header, footer and FAT
 func OpenBundle(filename string, cipherKey []byte) (*BundleFile, error) {
+	return openBundle(filename, cipherKey, false)
+}
+
+// OpenBundleLazy opens an exist bundle file like OpenBundle, but it loads
+// only the directory of the paged FAT. The items are not loaded into the
+// FAT.Items, they are found by binary search with GetItemByPath and can be
+// iterated with Walk. The bundle can't be modified. The bundles which are
+// created with VERSION_1 are loaded fully.
+func OpenBundleLazy(filename string, cipherKey []byte) (*BundleFile, error) {
+	return openBundle(filename, cipherKey, true)
+}
+
+func openBundle(filename string, cipherKey []byte, lazy bool) (*BundleFile, error) {
 
 	var f *os.File
 	var err error
+	readOnly := filename == "-"
 
 	if filename == "-" {
 		f = os.Stdin
@@ -90,7 +346,12 @@ func OpenBundle(filename string, cipherKey []byte) (*BundleFile, error) {
 		// Open package file
 		f, err = os.OpenFile(filename, os.O_RDWR, 0666)
 		if err != nil {
-			return nil, err
+			// The read-only files (e.g. the running executable) are opened for reading only
+			f, err = os.Open(filename)
+			if err != nil {
+				return nil, err
+			}
+			readOnly = true
 		}
 	}
 
@@ -100,178 +361,628 @@ func OpenBundle(filename string, cipherKey []byte) (*BundleFile, error) {
 		return nil, err
 	}
 
+	bundle, err := openReader(f, packFileInfo.Size(), cipherKey, lazy)
+	if err != nil {
+		if f != os.Stdin {
+			f.Close()
+		}
+		return nil, err
+	}
+
+	bundle.Path = filename
+	bundle.File = f
+	if !readOnly {
+		bundle.writer = f
+	}
+	return bundle, nil
+}
+
+// OpenBundleReader opens an exist bundle from the reader of the given size
+// (e.g. a remote file). The bundle can't be modified.
+func OpenBundleReader(reader io.ReaderAt, size int64, cipherKey []byte) (*BundleFile, error) {
+	return openReader(reader, size, cipherKey, false)
+}
+
+// openReader loads the header, the footer and the FAT of the bundle from
+// the reader with positional reads
+func openReader(reader io.ReaderAt, size int64, cipherKey []byte, lazy bool) (*BundleFile, error) {
+
 	// Check the size of package (minimum HEADER_SIZE + FOOTER_SIZE)
-	size := packFileInfo.Size()
 	if size < HEADER_SIZE+FOOTER_SIZE {
 		return nil, fmt.Errorf("File is too small! Size: %d", size)
 	}
 
-	// 1. Jump to end of file
-	_, err = f.Seek(-FOOTER_SIZE, os.SEEK_END)
+	// 1. Read file footer from the end of file
+	footer, err := GetFooter(io.NewSectionReader(reader, size-FOOTER_SIZE, FOOTER_SIZE))
 	if err != nil {
 		return nil, err
 	}
 
-	// Read file footer
-	footer, err := GetFooter(f)
-	if err != nil {
-		return nil, err
-	}
-
-	// Jump to the begin of bundle by PackSize (maybe bundle is behind other file)
-	fileBegin, err := f.Seek(-footer.PackSize, os.SEEK_END)
-	if err != nil {
-		return nil, err
+	// The begin of bundle by PackSize (maybe bundle is behind other file)
+	fileBegin := size - footer.PackSize
+	if footer.PackSize < HEADER_SIZE+FOOTER_SIZE || fileBegin < 0 {
+		return nil, fmt.Errorf("Invalid pack size! Size: %d", footer.PackSize)
 	}
 
 	// Calc base offset of data block
 	dataBaseOffset := fileBegin + HEADER_SIZE
 
 	// 3. Read file header
-	header, err := GetHeader(f)
+	header, err := GetHeader(io.NewSectionReader(reader, fileBegin, HEADER_SIZE))
 	if err != nil {
 		return nil, err
 	}
 
-	// 4. jump to FAT
-	_, err = f.Seek(-(FOOTER_SIZE + header.FatSize), os.SEEK_END)
-	if err != nil {
+	// Check the codecs of the bundle
+	if _, err := GetCompressor(header.Compress); err != nil {
 		return nil, err
 	}
+	if _, err := GetCipher(header.Encrypt); err != nil {
+		return nil, err
+	}
+
+	fatOffset := size - FOOTER_SIZE - header.FatSize
+	if header.FatSize < 0 || fatOffset < dataBaseOffset {
+		return nil, fmt.Errorf("Invalid FAT size! Size: %d", header.FatSize)
+	}
+
+	settings := BundleSettings{Compression: header.Compress, Encryption: header.Encrypt, CipherKey: cipherKey}
+	bundle := BundleFile{id: nextBundleID(), reader: reader, Header: header, Footer: footer, Settings: settings, DataBaseOffset: dataBaseOffset, fatOffset: fatOffset}
+
+	// 4. Read FAT
+	var fat *FAT
+	if header.Version >= VERSION_2 {
+		index, err := readIndex(reader, fatOffset, header, cipherKey)
+		if err != nil {
+			return nil, err
+		}
+		bundle.index = index
+		bundle.lazy = lazy
+		fat = &FAT{Count: index.Count, Size: index.Size, DictOffset: index.DictOffset, DictSize: index.DictSize}
+
+		if !lazy {
+			fat.Items, err = bundle.loadItems()
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		fatBuf := make([]byte, header.FatSize)
+		_, err = reader.ReadAt(fatBuf, fatOffset)
+		if err != nil {
+			return nil, err
+		}
+
+		// Transform back the FAT (decompress, decrypt)
+		fatContent, err := TransformUnpack(fatBuf, header.Compress, header.Encrypt, cipherKey)
+		if err != nil {
+			return nil, err
+		}
+
+		// Recover the FAT struct from JSON
+		fat, err = FATFromJSON(fatContent)
+		if err != nil {
+			return nil, err
+		}
+
+		// In the first version every file is compressed by the compression of the bundle
+		if header.Version == VERSION_1 {
+			for i := range fat.Items {
+				fat.Items[i].Compress = header.Compress
+			}
+		}
+
+		// Check the codecs of files
+		for _, item := range fat.Items {
+			if _, err := GetCompressor(item.Compress); err != nil {
+				return nil, err
+			}
+		}
+	}
+	bundle.FAT = *fat
 
-	// 5. Read FAT
-	fatBuf := make([]byte, header.FatSize)
-	_, err = f.Read(fatBuf)
+	// Load the shared dictionary
+	if fat.DictSize > 0 {
+		dict, err := bundle.readBlob(FATItem{Offset: fat.DictOffset, Size: fat.DictSize, Compress: header.Compress})
+		if err != nil {
+			return nil, err
+		}
+		bundle.Settings.Dictionary = dict
+	}
+
+	return &bundle, nil
+}
+
+// OpenBundleMmap opens an exist bundle file like OpenBundle, and maps the
+// file into the memory (only on Linux). The stored entries are read without
+// copy, so the content returned by ReadFile must not be modified and it is
+// valid until the bundle is closed. If the mapping is not available (e.g.
+// other OS or STDIN), it falls back to the regular reading.
+func OpenBundleMmap(filename string, cipherKey []byte) (*BundleFile, error) {
+	bundle, err := OpenBundle(filename, cipherKey)
 	if err != nil {
 		return nil, err
 	}
 
-	// Transform back the FAT (decompress, decrypt)
-	fatContent, err := TransformUnpack(fatBuf, header.Compress, header.Encrypt, cipherKey)
+	if filename != "-" {
+		if info, err := bundle.File.Stat(); err == nil && info.Mode().IsRegular() {
+			if mapped, err := mmapFile(bundle.File, info.Size()); err == nil {
+				bundle.mapped = mapped
+				bundle.reader = bytes.NewReader(mapped)
+			}
+		}
+	}
+
+	return bundle, nil
+}
+
+// IsMapped returns true if the bundle file is mapped into the memory
+func (this *BundleFile) IsMapped() bool {
+	return this.mapped != nil
+}
+
+// AddFile adds a file to the bundle file
+func (this *BundleFile) AddFile(relativePath, file string) (*FATItem, error) {
+	return this.addFile(relativePath, file, nil)
+}
+
+// addFile adds the file like AddFile, and reports the count of read bytes
+// to the progress callback (if it's not nil)
+func (this *BundleFile) addFile(relativePath, file string, progress func(n int64)) (*FATItem, error) {
+
+	item, content, err := this.readSourceFile(relativePath, file, progress)
 	if err != nil {
 		return nil, err
 	}
 
-	// Recover the FAT struct from JSON
-	fat, err := FATFromJSON(fatContent)
+	if this.streamed(item) {
+		return this.addStream(item, file, progress)
+	}
+
+	var blob []byte
+	if this.FindDuplicate(item) == nil {
+		// Transform content of file (encrypt, compress)
+		blob, err = this.transformContent(item, content)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return this.addItem(item, blob)
+}
+
+// prepareFile reads, hashes and transforms a file, but doesn't add it to
+// the bundle. It doesn't modify the bundle, so it can be called concurrently.
+// The large files are not read, they are streamed by addStream.
+func (this *BundleFile) prepareFile(relativePath, file string, progress func(n int64)) (*FATItem, []byte, error) {
+
+	item, content, err := this.readSourceFile(relativePath, file, progress)
+	if err != nil || this.streamed(item) {
+		return item, nil, err
+	}
+
+	// Transform content of file (encrypt, compress)
+	blob, err := this.transformContent(item, content)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	settings := BundleSettings{Compression: header.Compress, Encryption: header.Encrypt, CipherKey: cipherKey}
-	bundle := BundleFile{Path: filename, File: f, FAT: *fat, Header: header, Footer: footer, Settings: settings, DataBaseOffset: dataBaseOffset}
+	return item, blob, nil
+}
 
-	return &bundle, nil
+// transformContent compresses the content by the rules and encrypts it.
+// If the compressed content is not smaller, the content is stored without
+// compression. The used compression is set to the item. In solid mode the
+// small files are not transformed, they are compressed in segments by addItem.
+func (this *BundleFile) transformContent(item *FATItem, content []byte) ([]byte, error) {
+	res := content
+	item.Compress = COMPRESS_NONE
+
+	compression := this.Settings.CompressionOf(item.Path)
+	if this.Settings.Solid && compression == this.Settings.Compression && compression != COMPRESS_NONE && len(content) > 0 && len(content) < SOLID_SEGMENT_SIZE {
+		item.Solid = true
+		item.Compress = compression
+		return content, nil
+	}
+
+	if compression != COMPRESS_NONE && len(content) > 0 {
+		compressor, err := this.compressor(compression)
+		if err != nil {
+			return nil, err
+		}
+
+		// The small files are compressed with the shared dictionary
+		dictCompressor, useDict := compressor.(DictCompressor)
+		useDict = useDict && len(this.Settings.Dictionary) > 0 && len(content) < DICT_FILE_SIZE
+
+		var compressed []byte
+		if useDict {
+			compressed, err = dictCompressor.CompressDict(content, this.Settings.Dictionary, this.Settings.Level)
+		} else {
+			compressed, err = compressor.Compress(content, this.Settings.Level)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(compressed) < len(content) {
+			res = compressed
+			item.Compress = compression
+			item.Dict = useDict
+		}
+	}
+
+	// Encryption
+	return TransformPack(res, COMPRESS_NONE, this.Settings.Encryption, this.Settings.CipherKey)
 }
 
-// AddFile adds a file to the bundle file
-func (this *BundleFile) AddFile(relativePath, file string) (*FATItem, error) {
+// readSourceFile reads the content of the file and creates a FAT item with the hash of content.
+// The count of read bytes is reported to the progress callback (if it's not nil).
+// The content of the large files is not read (see streamed).
+func (this *BundleFile) readSourceFile(relativePath, file string, progress func(n int64)) (*FATItem, []byte, error) {
 
 	// Open source file
 	f, err := os.Open(file)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer f.Close()
 
 	// Get file info
 	fileInfo, err := f.Stat()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	fileMode := fileInfo.Mode()
 	fileSize := fileInfo.Size()
 
 	// Create a FAT item
-	item := FATItem{Path: filepath.ToSlash(relativePath), Offset: this.FAT.Size, OrigSize: fileSize, MTime: fileInfo.ModTime().UnixNano(), Mode: uint32(fileMode), Perm: uint32(fileMode.Perm())}
+	item := FATItem{Path: filepath.ToSlash(relativePath), OrigSize: fileSize, MTime: fileInfo.ModTime().UnixNano(), Mode: uint32(fileMode), Perm: uint32(fileMode.Perm())}
+	if this.streamed(&item) {
+		return &item, nil, nil
+	}
 
 	// Read content of file
-	content, err := ioutil.ReadFile(FixPath(file))
+	var reader io.Reader = f
+	if progress != nil {
+		reader = progressReader{f, progress}
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, fileSize+bytes.MinRead))
+	_, err = buf.ReadFrom(reader)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	content := buf.Bytes()
 
 	// Calc hash from content
 	item.Hash = sha512.Sum512(content)
 
+	return &item, content, nil
+}
+
+// streamed returns true if the file is larger than a frame. The content of
+// these files is not read into the memory, it is streamed by addStream.
+func (this *BundleFile) streamed(item *FATItem) bool {
+	return item.OrigSize > int64(this.frameSize(this.Settings.CompressionOf(item.Path)))
+}
+
+// addStream adds the large file to the bundle. The content is streamed in
+// frames to the end of the data block (see writeFrames). If the bundle
+// contains a file with the same size, the file is hashed before, so the
+// duplicated content is not written.
+func (this *BundleFile) addStream(item *FATItem, file string, progress func(n int64)) (*FATItem, error) {
+	err := this.checkWritable()
+	if err != nil {
+		return nil, err
+	}
+
+	if this.hasSize(item.OrigSize) {
+		item.Hash, err = hashFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if this.findDuplicate(item) >= 0 {
+			if progress != nil {
+				progress(item.OrigSize)
+			}
+			return this.addItem(item, nil)
+		}
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var reader io.Reader = f
+	if progress != nil {
+		reader = progressReader{f, progress}
+	}
+
+	err = this.writeFrames(item, reader, this.Settings.CompressionOf(item.Path))
+	if err != nil {
+		return nil, err
+	}
+
+	return this.appendItem(item)
+}
+
+// hashFile calculates the hash of the content of the file
+func hashFile(file string) ([64]byte, error) {
+	var res [64]byte
+
+	f, err := os.Open(file)
+	if err != nil {
+		return res, err
+	}
+	defer f.Close()
+
+	hash := sha512.New()
+	_, err = io.Copy(hash, f)
+	if err != nil {
+		return res, err
+	}
+
+	copy(res[:], hash.Sum(nil))
+	return res, nil
+}
+
+// addItem adds the item with the transformed content to the bundle.
+// If the content is duplicated, the blob is skipped. The content of solid
+// items is added to the pending segment, so their offset & size are set
+// when the segment is written.
+func (this *BundleFile) addItem(item *FATItem, blob []byte) (*FATItem, error) {
+	err := this.checkWritable()
+	if err != nil {
+		return nil, err
+	}
+
 	// Find duplicated files by hash & size
-	dup := this.FindDuplicate(&item)
-	if dup != nil {
+	if i := this.findDuplicate(item); i >= 0 {
+		dup := this.FAT.Items[i]
+
 		// Inc duplicated counters
 		this.DupCount++
-		this.DupSize += dup.Size
 		item.Offset = dup.Offset
 		item.Size = dup.Size
-	} else {
-		// Transform content of file (encrypt, compress)
-		blob, err := TransformPack(content, this.Settings.Compression, this.Settings.Encryption, this.Settings.CipherKey)
-		if err != nil {
-			return nil, err
+		item.Compress = dup.Compress
+		item.Solid = dup.Solid
+		item.SolidOffset = dup.SolidOffset
+		item.Dict = dup.Dict
+		item.Frames = dup.Frames
+		item.FrameSize = dup.FrameSize
+
+		if dup.Solid {
+			this.DupSize += dup.OrigSize
+
+			// The duplicated item is in the pending segment
+			if this.segment != nil && i >= this.segment.items[0] {
+				this.segment.items = append(this.segment.items, len(this.FAT.Items))
+			}
+		} else {
+			this.DupSize += dup.Size
+		}
+	} else if item.Solid {
+		if this.segment == nil {
+			this.segment = &solidSegment{}
 		}
+		item.Offset = 0
+		item.Size = 0
+		item.SolidOffset = int64(this.segment.content.Len())
+		this.segment.content.Write(blob)
+		this.segment.items = append(this.segment.items, len(this.FAT.Items))
+	} else {
+		item.Offset = this.FAT.Size
 		item.Size = int64(len(blob))
-		this.FAT.Size += item.Size
 
-		// jump to the end of the data block
-		_, err = this.File.Seek(this.DataBaseOffset+item.Offset, os.SEEK_SET)
+		err = this.writeBlob(blob)
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		// Write transformed content to package
-		_, err = this.File.Write(blob)
-		if err != nil {
-			return nil, err
-		}
+	return this.appendItem(item)
+}
+
+// checkWritable returns an error if the bundle can't be modified
+func (this *BundleFile) checkWritable() error {
+	if this.lazy {
+		return errors.New("The lazily opened bundle can't be modified!")
+	}
+	if this.writer == nil {
+		return errors.New("The bundle is opened read-only!")
 	}
+	return nil
+}
+
+// appendItem appends the item (with written content) to the FAT, and
+// writes the pending solid segment if it is full
+func (this *BundleFile) appendItem(item *FATItem) (*FATItem, error) {
+	this.resetPathIndex()
+
 	// Add new item to FAT
-	this.FAT.Items = append(this.FAT.Items, item)
+	this.FAT.Items = append(this.FAT.Items, *item)
 	this.FAT.Count++
 
 	this.edited = true
 
-	return &item, nil
+	// Write the segment if it is full
+	if this.segment != nil && this.segment.content.Len() >= SOLID_SEGMENT_SIZE {
+		err := this.flushSegment()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return item, nil
+}
+
+// writeBlob writes the transformed content to the end of the data block
+func (this *BundleFile) writeBlob(blob []byte) error {
+
+	// Write transformed content to the end of the data block
+	_, err := this.writer.WriteAt(blob, this.DataBaseOffset+this.FAT.Size)
+	if err != nil {
+		return err
+	}
+
+	this.FAT.Size += int64(len(blob))
+	return nil
+}
+
+// flushSegment compresses & writes the pending solid segment and sets the
+// offset & size of its files
+func (this *BundleFile) flushSegment() error {
+	segment := this.segment
+	if segment == nil {
+		return nil
+	}
+	this.segment = nil
+	this.resetPathIndex()
+
+	compression := this.Settings.Compression
+	compressor, err := this.compressor(compression)
+	if err != nil {
+		return err
+	}
+
+	content := segment.content.Bytes()
+	res, err := compressor.Compress(content, this.Settings.Level)
+	if err != nil {
+		return err
+	}
+	if len(res) >= len(content) {
+		res = content
+		compression = COMPRESS_NONE
+	}
+
+	// Encryption
+	blob, err := TransformPack(res, COMPRESS_NONE, this.Settings.Encryption, this.Settings.CipherKey)
+	if err != nil {
+		return err
+	}
+
+	offset := this.FAT.Size
+	err = this.writeBlob(blob)
+	if err != nil {
+		return err
+	}
+
+	for _, i := range segment.items {
+		this.FAT.Items[i].Offset = offset
+		this.FAT.Items[i].Size = int64(len(blob))
+		this.FAT.Items[i].Compress = compression
+	}
+	return nil
 }
 
 // ReadFileFromPath searches the FATItem in FAT by `filepath`` and reads
 // the content of the file from the bundle
 func (this *BundleFile) ReadFileFromPath(filepath string) ([]byte, error) {
-	for _, item := range this.FAT.Items {
-		if item.Path == filepath {
-			return this.ReadFile(item)
-		}
+	item, err := this.GetItemByPath(filepath)
+	if err != nil {
+		return nil, err
 	}
-	return nil, errors.New("File not found! Path: " + filepath)
+	return this.ReadFile(*item)
 }
 
-// GetItemByPath searches the FATItem in FAT by `filepath`` and return the found item
+// GetItemByPath searches the FATItem in FAT by `filepath`` and return the found item.
+// It is searched in the path map, or in the FAT index on the lazily opened bundles.
 func (this *BundleFile) GetItemByPath(filepath string) (*FATItem, error) {
-	for _, item := range this.FAT.Items {
-		if item.Path == filepath {
-			return &item, nil
-		}
+	if this.lazy {
+		return this.lookupItem(filepath)
+	}
+	items, _ := this.pathIndex()
+	if i, found := items[filepath]; found {
+		item := this.FAT.Items[i]
+		return &item, nil
 	}
 	return nil, errors.New("File not found! Path: " + filepath)
 }
 
-// ReadFile reads the content of the file from the bundle
+// ReadFile reads the content of the file from the bundle.
+// It is safe to call concurrently from many goroutines. If the Cache
+// is set, the content is cached and a copy of it is returned.
 func (this *BundleFile) ReadFile(item FATItem) ([]byte, error) {
+	if this.Cache == nil {
+		return this.readFile(item)
+	}
 
-	// Seek to content
-	_, err := this.File.Seek(this.DataBaseOffset+item.Offset, os.SEEK_SET)
-	if err != nil {
-		return nil, err
+	key := this.cacheKeyOf(item)
+	content, found := this.Cache.get(key)
+	if !found {
+		var err error
+		content, err = this.readFile(item)
+		if err != nil {
+			return nil, err
+		}
+		this.Cache.put(key, content)
+	}
+
+	return append([]byte(nil), content...), nil
+}
+
+// readFile reads & decodes the content of the file from the bundle
+func (this *BundleFile) readFile(item FATItem) ([]byte, error) {
+	if !item.Solid {
+		return this.readBlob(item)
 	}
 
-	// Read the content
-	blob := make([]byte, item.Size)
-	_, err = this.File.Read(blob)
+	// Read the file from the decoded segment
+	this.segmentCache.lock.Lock()
+	content := this.segmentCache.content
+	if content == nil || this.segmentCache.offset != item.Offset {
+		this.segmentCache.lock.Unlock()
+
+		var err error
+		content, err = this.readBlob(item)
+		if err != nil {
+			return nil, err
+		}
+
+		this.segmentCache.lock.Lock()
+		this.segmentCache.offset = item.Offset
+		this.segmentCache.content = content
+	}
+	this.segmentCache.lock.Unlock()
+
+	if item.SolidOffset < 0 || item.SolidOffset+item.OrigSize > int64(len(content)) {
+		return nil, errors.New("Invalid solid segment! Path: " + item.Path)
+	}
+
+	return append([]byte(nil), content[item.SolidOffset:item.SolidOffset+item.OrigSize]...), nil
+}
+
+// ReadRawFile reads the stored content of the item (or the solid segment)
+// without decryption & decompression
+func (this *BundleFile) ReadRawFile(item FATItem) ([]byte, error) {
+	return this.readRaw(this.DataBaseOffset+item.Offset, item.Size)
+}
+
+// readBlob reads the content (or the solid segment) of the item and transforms it back
+func (this *BundleFile) readBlob(item FATItem) ([]byte, error) {
+
+	blob, err := this.readRaw(this.DataBaseOffset+item.Offset, item.Size)
 	if err != nil {
 		return nil, err
 	}
 
+	if item.Frames != nil {
+		return this.readFrames(item, blob)
+	}
+
+	var dict []byte
+	if item.Dict {
+		dict = this.Settings.Dictionary
+		if len(dict) == 0 {
+			return nil, errors.New("Missing dictionary! Path: " + item.Path)
+		}
+	}
+
 	// Transform back (decompress, decrypt)
-	content, err := TransformUnpack(blob, this.Settings.Compression, this.Settings.Encryption, this.Settings.CipherKey)
+	content, err := transformUnpackDict(blob, item.Compress, dict, this.Settings.Encryption, this.Settings.CipherKey)
 	if err != nil {
 		return nil, err
 	}
@@ -279,48 +990,97 @@ func (this *BundleFile) ReadFile(item FATItem) ([]byte, error) {
 	return content, nil
 }
 
+// readRaw reads `size` bytes of the bundle file from the offset. If the file
+// is mapped, it returns the slice of the mapped memory without copy.
+func (this *BundleFile) readRaw(offset int64, size int64) ([]byte, error) {
+	if this.mapped != nil {
+		if offset < 0 || size < 0 || offset+size > int64(len(this.mapped)) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return this.mapped[offset : offset+size : offset+size], nil
+	}
+	if this.reader == nil {
+		return nil, errors.New("The bundle is not readable!")
+	}
+
+	// Read the content (with positional read, so it doesn't move the file offset)
+	blob := make([]byte, size)
+	_, err := this.reader.ReadAt(blob, offset)
+	if err != nil {
+		return nil, err
+	}
+	return blob, nil
+}
+
+// readRawAt reads len(p) bytes of the bundle at the offset to p
+func (this *BundleFile) readRawAt(p []byte, offset int64) (int, error) {
+	if this.reader == nil {
+		return 0, errors.New("The bundle is not readable!")
+	}
+	return this.reader.ReadAt(p, offset)
+}
+
 // Finalize writes the footer of bundle
 func (this *BundleFile) Finalize() error {
 	if this.edited {
-		// Encode FAT to JSON
-		json, err := this.FAT.JSON()
+		// Write the pending solid segment
+		err := this.flushSegment()
 		if err != nil {
 			return err
 		}
 
-		// Transform FAT (encrypt, compress)
-		fatBlob, err := TransformPack(json, this.Settings.Compression, this.Settings.Encryption, this.Settings.CipherKey)
+		// Encode FAT to the paged index (sort, encrypt, compress)
+		fatBlob, err := encodeIndex(this.FAT, this.Settings.Compression, this.Settings.Encryption, this.Settings.CipherKey)
 		if err != nil {
 			return err
 		}
 
-		// jump to the end of the data block
-		_, err = this.File.Seek(this.DataBaseOffset+this.FAT.Size, os.SEEK_SET)
+		// Write FAT to the end of the data block
+		fatOffset := this.DataBaseOffset + this.FAT.Size
+		this.Header.FatSize = int64(len(fatBlob))
+		this.Header.Version = VERSION
+		_, err = this.writer.WriteAt(fatBlob, fatOffset)
 		if err != nil {
 			return err
 		}
 
-		// Write FAT to package
-		this.Header.FatSize = int64(len(fatBlob))
-		this.File.Write(fatBlob)
-
 		// Set the PackSize in the footer
 		this.Footer.PackSize = HEADER_SIZE + this.FAT.Size + this.Header.FatSize + FOOTER_SIZE
 
 		// Refresh FatSize in the header of package
-		this.File.Seek(0, os.SEEK_SET)
-		err = this.Header.Write(this.File)
+		err = this.writeHeader()
 		if err != nil {
 			return err
 		}
 
 		// Write footer
-		this.File.Seek(0, os.SEEK_END)
-		err = this.Footer.Write(this.File)
+		buf := new(bytes.Buffer)
+		err = this.Footer.Write(buf)
+		if err != nil {
+			return err
+		}
+		_, err = this.writer.WriteAt(buf.Bytes(), fatOffset+this.Header.FatSize)
 		if err != nil {
 			return err
 		}
 
+		// Cut the rest of the previous FAT of the reopened bundle
+		if this.File != nil {
+			err = this.File.Truncate(fatOffset + this.Header.FatSize + FOOTER_SIZE)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Store the bundle in the storage
+		if writer, ok := this.writer.(StorageWriter); ok {
+			err = writer.Commit()
+			if err != nil {
+				return err
+			}
+			this.writer = nil
+			this.edited = false
+		}
 	}
 
 	return nil
@@ -328,6 +1088,31 @@ func (this *BundleFile) Finalize() error {
 
 // Close closes the bundle File
 func (this *BundleFile) Close() error {
+	if this.mapped != nil {
+		// The cached contents can be slices of the mapped memory
+		this.segmentCache.lock.Lock()
+		this.segmentCache.content = nil
+		this.segmentCache.lock.Unlock()
+		this.pageCache.lock.Lock()
+		this.pageCache.items = nil
+		this.pageCache.lock.Unlock()
+		if this.Cache != nil {
+			this.Cache.invalidateBundle(this.id)
+		}
+
+		munmapFile(this.mapped)
+		this.mapped = nil
+		this.reader = this.File
+	}
+	if writer, ok := this.writer.(StorageWriter); ok {
+		// The bundle is not finalized
+		writer.Abort()
+		this.writer = nil
+	}
+	if this.closer != nil {
+		this.closer.Close()
+		this.closer = nil
+	}
 	if this.File != nil {
 		this.File.Close()
 		this.File = nil
@@ -1,10 +1,13 @@
 package icepacker
 
 import (
+	"bytes"
 	"crypto/sha512"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -103,8 +106,8 @@ func TestCreateBundle(t *testing.T) {
 		So(err, ShouldBeNil)
 
 		So(bundle.Footer, ShouldNotBeNil)
-		So(bundle.Footer.PackSize, ShouldEqual, 180)
-		So(bundle.Header.FatSize, ShouldEqual, 134)
+		So(bundle.Footer.PackSize, ShouldEqual, 351)
+		So(bundle.Header.FatSize, ShouldEqual, 305)
 
 		// Close the bundle
 		err = bundle.Close()
@@ -201,8 +204,8 @@ func TestOpenBundle(t *testing.T) {
 		So(err, ShouldBeNil)
 
 		So(bundle.Footer, ShouldNotBeNil)
-		So(bundle.Footer.PackSize, ShouldEqual, 3812)
-		So(bundle.Header.FatSize, ShouldEqual, 595)
+		So(bundle.Footer.PackSize, ShouldEqual, 4421)
+		So(bundle.Header.FatSize, ShouldEqual, 1204)
 
 		// Close the bundle
 		err = bundle.Close()
@@ -239,7 +242,10 @@ func TestReadFile(t *testing.T) {
 	})
 
 	Convey("read a file by item", t, func() {
-		content, err := bundle.ReadFile(bundle.FAT.Items[1])
+		item, err := bundle.GetItemByPath("file2.txt")
+		So(err, ShouldBeNil)
+
+		content, err := bundle.ReadFile(*item)
 		So(err, ShouldBeNil)
 		So(content, ShouldNotBeNil)
 		So(len(content), ShouldEqual, 14)
@@ -257,3 +263,151 @@ func TestReadFile(t *testing.T) {
 		os.Remove(bundlePath)
 	})
 }
+
+func TestConcurrentReadFile(t *testing.T) {
+
+	Convey("Should read files from many goroutines", t, func() {
+
+		source, _ := filepath.Abs("testdata/simple")
+		target, _ := filepath.Abs("testdata/packed/concurrent.pack")
+
+		result := Pack(PackSettings{
+			SourceDir:      source,
+			TargetFilename: target,
+			Compression:    COMPRESS_GZIP,
+			Encryption:     ENCRYPT_AES,
+			Cipher:         NewCipherSettings("secret"),
+		})
+		So(result.Err, ShouldBeNil)
+
+		bundle, err := OpenBundle(target, HashingKey(NewCipherSettings("secret")))
+		So(err, ShouldBeNil)
+
+		expected := map[string][]byte{}
+		for _, item := range bundle.FAT.Items {
+			expected[item.Path], _ = ioutil.ReadFile(filepath.Join(source, filepath.FromSlash(item.Path)))
+		}
+
+		var mutex sync.Mutex
+		failed := []string{}
+
+		var wg sync.WaitGroup
+		for g := 0; g < 16; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for n := 0; n < 20; n++ {
+					item := bundle.FAT.Items[(g+n)%len(bundle.FAT.Items)]
+					content, err := bundle.ReadFileFromPath(item.Path)
+					if err != nil || string(content) != string(expected[item.Path]) {
+						mutex.Lock()
+						failed = append(failed, item.Path)
+						mutex.Unlock()
+					}
+				}
+			}(g)
+		}
+		wg.Wait()
+
+		So(failed, ShouldBeEmpty)
+
+		bundle.Close()
+		os.Remove(target)
+	})
+}
+
+func TestCompressionPolicy(t *testing.T) {
+
+	source, _ := filepath.Abs("testdata/simple")
+	target, _ := filepath.Abs("testdata/packed/policy.pack")
+
+	Convey("Should record the used compression of files", t, func() {
+
+		result := Pack(PackSettings{
+			SourceDir:      source,
+			TargetFilename: target,
+			Compression:    COMPRESS_GZIP,
+			Level:          5,
+			Rules:          []CompressRule{{"index.html", COMPRESS_NONE}},
+		})
+		So(result.Err, ShouldBeNil)
+
+		bundle, err := OpenBundle(target, nil)
+		So(err, ShouldBeNil)
+		defer bundle.Close()
+
+		var tests = []struct {
+			path     string
+			compress byte
+		}{
+			// Compressible
+			{"dir1/file3.txt", COMPRESS_GZIP},
+			// Selected by rule
+			{"dir2/index.html", COMPRESS_NONE},
+			// Not smaller with compression
+			{"dir1/icon1.png", COMPRESS_NONE},
+			{"dir2/icon-same.png", COMPRESS_NONE},
+			{"file1.txt", COMPRESS_NONE},
+			{"empty.txt", COMPRESS_NONE},
+		}
+
+		for _, test := range tests {
+			item, err := bundle.GetItemByPath(test.path)
+			So(err, ShouldBeNil)
+			So(item.Compress, ShouldEqual, test.compress)
+
+			content, err := bundle.ReadFile(*item)
+			So(err, ShouldBeNil)
+			origContent, _ := ioutil.ReadFile(filepath.Join(source, filepath.FromSlash(test.path)))
+			So(string(content), ShouldEqual, string(origContent))
+
+			if test.compress == COMPRESS_NONE {
+				So(item.Size, ShouldEqual, item.OrigSize)
+			} else {
+				So(item.Size, ShouldBeLessThan, item.OrigSize)
+			}
+		}
+
+		os.Remove(target)
+	})
+
+	Convey("Should give error if the level is invalid", t, func() {
+
+		_, err := CreateBundle(target, BundleSettings{Compression: COMPRESS_GZIP, Level: 10})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "Invalid compression level (10)!")
+	})
+
+	Convey("Should read the files of a first version bundle", t, func() {
+
+		content := []byte("Content of the file in a first version bundle. Content of the file.")
+		blob, _ := compress(content)
+		fatJSON := []byte(`{"count":1,"size":` + fmt.Sprint(len(blob)) + `,"items":[{"path":"file.txt","offset":0,"size":` + fmt.Sprint(len(blob)) + `,"origSize":` + fmt.Sprint(len(content)) + `,"mTime":0,"mode":420,"perm":420}]}`)
+		fatBlob, _ := compress(fatJSON)
+
+		header := NewHeader(ENCRYPT_NONE, COMPRESS_GZIP)
+		header.Version = VERSION_1
+		header.FatSize = int64(len(fatBlob))
+		footer := NewFooter()
+		footer.PackSize = HEADER_SIZE + int64(len(blob)+len(fatBlob)) + FOOTER_SIZE
+
+		buf := new(bytes.Buffer)
+		header.Write(buf)
+		buf.Write(blob)
+		buf.Write(fatBlob)
+		footer.Write(buf)
+		So(ioutil.WriteFile(target, buf.Bytes(), 0644), ShouldBeNil)
+
+		bundle, err := OpenBundle(target, nil)
+		So(err, ShouldBeNil)
+		So(bundle.Header.Version, ShouldEqual, VERSION_1)
+		So(bundle.FAT.Items[0].Compress, ShouldEqual, COMPRESS_GZIP)
+
+		res, err := bundle.ReadFileFromPath("file.txt")
+		So(err, ShouldBeNil)
+		So(res, ShouldResemble, content)
+
+		bundle.Close()
+		os.Remove(target)
+	})
+}
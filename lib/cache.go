@@ -0,0 +1,151 @@
+package icepacker
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// ContentCache is a bounded LRU cache of the decoded contents of files.
+// The size of the cached contents is limited in bytes. It is safe for
+// concurrent use from many goroutines, and it can be shared between
+// bundles (the contents are keyed by the bundle too).
+type ContentCache struct {
+	lock    sync.Mutex
+	limit   int64
+	size    int64
+	list    *list.List
+	entries map[cacheKey]*list.Element
+	hits    int64
+	misses  int64
+}
+
+// CacheStats records the statistics of a ContentCache
+type CacheStats struct {
+	Hits   int64
+	Misses int64
+	Count  int
+	Size   int64
+	Limit  int64
+}
+
+// cacheKey identifies the content of a file in the bundle. The duplicated
+// files have the same key.
+type cacheKey struct {
+	Bundle      uint64
+	Offset      int64
+	SolidOffset int64
+	OrigSize    int64
+}
+
+// cacheEntry is an element of the LRU list
+type cacheEntry struct {
+	key     cacheKey
+	content []byte
+}
+
+// NewContentCache creates a new ContentCache with the size limit in bytes
+func NewContentCache(limit int64) *ContentCache {
+	return &ContentCache{
+		limit:   limit,
+		list:    list.New(),
+		entries: make(map[cacheKey]*list.Element),
+	}
+}
+
+// lastBundleID is the last ID of the opened or created bundles
+var lastBundleID uint64
+
+// nextBundleID returns a new unique ID of a bundle
+func nextBundleID() uint64 {
+	return atomic.AddUint64(&lastBundleID, 1)
+}
+
+// cacheKeyOf returns the key of the content of the item in the bundle
+func (this *BundleFile) cacheKeyOf(item FATItem) cacheKey {
+	return cacheKey{this.id, item.Offset, item.SolidOffset, item.OrigSize}
+}
+
+// get returns the cached content and moves it to the front of the list
+func (this *ContentCache) get(key cacheKey) ([]byte, bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	element, found := this.entries[key]
+	if !found {
+		this.misses++
+		return nil, false
+	}
+
+	this.hits++
+	this.list.MoveToFront(element)
+	return element.Value.(*cacheEntry).content, true
+}
+
+// put adds the content to the cache and evicts the least recently used
+// contents over the limit. The content larger than the limit is not cached.
+func (this *ContentCache) put(key cacheKey, content []byte) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if int64(len(content)) > this.limit {
+		return
+	}
+
+	if element, found := this.entries[key]; found {
+		this.list.MoveToFront(element)
+		return
+	}
+
+	this.entries[key] = this.list.PushFront(&cacheEntry{key, content})
+	this.size += int64(len(content))
+
+	for this.size > this.limit {
+		this.remove(this.list.Back())
+	}
+}
+
+// remove removes the element from the cache
+func (this *ContentCache) remove(element *list.Element) {
+	entry := this.list.Remove(element).(*cacheEntry)
+	delete(this.entries, entry.key)
+	this.size -= int64(len(entry.content))
+}
+
+// Invalidate removes all contents from the cache. The counters are kept.
+func (this *ContentCache) Invalidate() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.list.Init()
+	this.entries = make(map[cacheKey]*list.Element)
+	this.size = 0
+}
+
+// invalidateBundle removes the contents of the bundle from the cache
+func (this *ContentCache) invalidateBundle(id uint64) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	for element := this.list.Front(); element != nil; {
+		next := element.Next()
+		if element.Value.(*cacheEntry).key.Bundle == id {
+			this.remove(element)
+		}
+		element = next
+	}
+}
+
+// Stats returns the statistics of the cache
+func (this *ContentCache) Stats() CacheStats {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	return CacheStats{
+		Hits:   this.hits,
+		Misses: this.misses,
+		Count:  this.list.Len(),
+		Size:   this.size,
+		Limit:  this.limit,
+	}
+}
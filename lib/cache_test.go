@@ -0,0 +1,203 @@
+package icepacker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestContentCache(t *testing.T) {
+
+	key := func(i int) cacheKey {
+		return cacheKey{Offset: int64(i)}
+	}
+
+	Convey("Should evict the least recently used contents", t, func() {
+
+		cache := NewContentCache(30)
+		cache.put(key(1), make([]byte, 10))
+		cache.put(key(2), make([]byte, 10))
+		cache.put(key(3), make([]byte, 10))
+
+		// Use the first, so the second is the least recently used
+		_, found := cache.get(key(1))
+		So(found, ShouldBeTrue)
+
+		cache.put(key(4), make([]byte, 5))
+
+		_, found = cache.get(key(2))
+		So(found, ShouldBeFalse)
+		for _, i := range []int{1, 3, 4} {
+			_, found = cache.get(key(i))
+			So(found, ShouldBeTrue)
+		}
+
+		So(cache.Stats(), ShouldResemble, CacheStats{Hits: 4, Misses: 1, Count: 3, Size: 25, Limit: 30})
+	})
+
+	Convey("Should not cache the content which is larger than the limit", t, func() {
+
+		cache := NewContentCache(30)
+		cache.put(key(1), make([]byte, 10))
+		cache.put(key(2), make([]byte, 31))
+
+		_, found := cache.get(key(2))
+		So(found, ShouldBeFalse)
+		So(cache.Stats().Count, ShouldEqual, 1)
+	})
+
+	Convey("Should invalidate the cache", t, func() {
+
+		cache := NewContentCache(30)
+		cache.put(key(1), make([]byte, 10))
+		cache.get(key(1))
+		cache.Invalidate()
+
+		_, found := cache.get(key(1))
+		So(found, ShouldBeFalse)
+		So(cache.Stats(), ShouldResemble, CacheStats{Hits: 1, Misses: 1, Count: 0, Size: 0, Limit: 30})
+	})
+
+	Convey("Should invalidate the contents of a bundle", t, func() {
+
+		cache := NewContentCache(30)
+		cache.put(cacheKey{Bundle: 1, Offset: 1}, make([]byte, 10))
+		cache.put(cacheKey{Bundle: 2, Offset: 1}, make([]byte, 10))
+		cache.invalidateBundle(1)
+
+		_, found := cache.get(cacheKey{Bundle: 1, Offset: 1})
+		So(found, ShouldBeFalse)
+		_, found = cache.get(cacheKey{Bundle: 2, Offset: 1})
+		So(found, ShouldBeTrue)
+		So(cache.Stats().Size, ShouldEqual, 10)
+	})
+
+	Convey("Should be used concurrently", t, func() {
+
+		cache := NewContentCache(100)
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < 1000; i++ {
+					if _, found := cache.get(key(i % 20)); !found {
+						cache.put(key(i%20), make([]byte, 10))
+					}
+					if i%100 == g {
+						cache.Invalidate()
+					}
+				}
+			}(g)
+		}
+		wg.Wait()
+
+		stats := cache.Stats()
+		So(stats.Hits+stats.Misses, ShouldEqual, 8000)
+		So(stats.Size, ShouldBeLessThanOrEqualTo, 100)
+		So(stats.Size, ShouldEqual, int64(stats.Count*10))
+	})
+}
+
+func TestBundleCache(t *testing.T) {
+
+	Convey("Should cache the decoded contents of files", t, func() {
+
+		source, _ := filepath.Abs("testdata/simple")
+		target, _ := filepath.Abs("testdata/packed/cache.pack")
+		defer os.Remove(target)
+
+		result := Pack(PackSettings{
+			SourceDir:      source,
+			TargetFilename: target,
+			Compression:    COMPRESS_GZIP,
+			Encryption:     ENCRYPT_AES,
+			Cipher:         NewCipherSettings("PackSecretKey"),
+		})
+		So(result.Err, ShouldBeNil)
+
+		bundle, err := OpenBundle(target, HashingKey(NewCipherSettings("PackSecretKey")))
+		So(err, ShouldBeNil)
+		defer bundle.Close()
+
+		bundle.Cache = NewContentCache(1 << 20)
+
+		for i := 0; i < 3; i++ {
+			content, err := bundle.ReadFileFromPath("dir1/file3.txt")
+			So(err, ShouldBeNil)
+			So(string(content), ShouldStartWith, "Lorem ipsum")
+
+			// Modify the returned copy
+			content[0] = 'X'
+		}
+
+		// The duplicated file has the same content
+		content, err := bundle.ReadFileFromPath("dir1/icon1.png")
+		So(err, ShouldBeNil)
+		dupContent, err := bundle.ReadFileFromPath("dir2/icon-same.png")
+		So(err, ShouldBeNil)
+		So(dupContent, ShouldResemble, content)
+
+		So(bundle.Cache.Stats().Hits, ShouldEqual, 3)
+		So(bundle.Cache.Stats().Misses, ShouldEqual, 2)
+		So(bundle.Cache.Stats().Count, ShouldEqual, 2)
+
+		Convey("read files concurrently", func() {
+			var wg sync.WaitGroup
+			errs := make(chan error, 64)
+			for g := 0; g < 8; g++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for _, item := range bundle.FAT.Items {
+						content, err := bundle.ReadFile(item)
+						if err == nil && int64(len(content)) != item.OrigSize {
+							err = fmt.Errorf("Invalid size of %s", item.Path)
+						}
+						if err != nil {
+							errs <- err
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			close(errs)
+			So(len(errs), ShouldEqual, 0)
+		})
+	})
+
+	Convey("Should share the cache between bundles", t, func() {
+
+		// The bundles have the same layout with different contents
+		targets := []string{}
+		for i, content := range []string{"first content", "other content"} {
+			target, _ := filepath.Abs(fmt.Sprintf("testdata/packed/shared-cache%d.pack", i))
+			defer os.Remove(target)
+
+			bundle, err := CreateBundle(target, BundleSettings{})
+			So(err, ShouldBeNil)
+			_, err = bundle.addItem(&FATItem{Path: "file.txt", OrigSize: int64(len(content))}, []byte(content))
+			So(err, ShouldBeNil)
+			So(bundle.Finalize(), ShouldBeNil)
+			bundle.Close()
+			targets = append(targets, target)
+		}
+
+		cache := NewContentCache(1 << 20)
+		for i, expected := range []string{"first content", "other content"} {
+			bundle, err := OpenBundle(targets[i], nil)
+			So(err, ShouldBeNil)
+			defer bundle.Close()
+			bundle.Cache = cache
+
+			content, err := bundle.ReadFileFromPath("file.txt")
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, expected)
+		}
+		So(cache.Stats().Count, ShouldEqual, 2)
+	})
+}
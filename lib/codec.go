@@ -0,0 +1,286 @@
+package icepacker
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressor compresses & decompresses the content of files. The methods
+// can be called concurrently from many goroutines.
+type Compressor interface {
+	// Name returns the name of the compression (e.g. "gzip")
+	Name() string
+
+	// MaxLevel returns the maximum level of the compression. The levels
+	// are 1..MaxLevel, LEVEL_DEFAULT means the default level. If it is 0,
+	// the compression has no levels and the level is ignored.
+	MaxLevel() int
+
+	// Compress compresses the data with the level
+	Compress(data []byte, level int) ([]byte, error)
+
+	// Decompress decompresses the data created by `Compress`. It must not modify the data.
+	Decompress(data []byte) ([]byte, error)
+}
+
+// DictCompressor is a Compressor which can compress the content with a
+// shared dictionary
+type DictCompressor interface {
+	Compressor
+
+	// CompressDict compresses the data with the dictionary and the level
+	CompressDict(data []byte, dict []byte, level int) ([]byte, error)
+
+	// DecompressDict decompresses the data created by `CompressDict` with the same dictionary
+	DecompressDict(data []byte, dict []byte) ([]byte, error)
+}
+
+// Cipher encrypts & decrypts the content of files. The methods can be
+// called concurrently from many goroutines.
+type Cipher interface {
+	// Name returns the name of the encryption (e.g. "aes")
+	Name() string
+
+	// Encrypt encrypts the data with the hashed key
+	Encrypt(data []byte, key []byte) ([]byte, error)
+
+	// Decrypt decrypts the data created by `Encrypt`. It must not modify the data.
+	Decrypt(data []byte, key []byte) ([]byte, error)
+}
+
+// UnsupportedCodecError is returned if no codec is registered with the ID
+type UnsupportedCodecError struct {
+	Kind string
+	ID   byte
+}
+
+func (this UnsupportedCodecError) Error() string {
+	return fmt.Sprintf("unsupported codec %d (%s)", this.ID, this.Kind)
+}
+
+var (
+	codecLock   sync.RWMutex
+	compressors = map[byte]Compressor{}
+	ciphers     = map[byte]Cipher{}
+)
+
+// RegisterCompressor registers a compressor with the ID which is stored
+// in the header of the bundle and in the FAT items. An already registered
+// compressor with the same ID is replaced.
+func RegisterCompressor(id byte, compressor Compressor) {
+	codecLock.Lock()
+	defer codecLock.Unlock()
+	compressors[id] = compressor
+}
+
+// RegisterCipher registers a cipher with the ID which is stored in the
+// header of the bundle. An already registered cipher with the same ID is replaced.
+func RegisterCipher(id byte, cipher Cipher) {
+	codecLock.Lock()
+	defer codecLock.Unlock()
+	ciphers[id] = cipher
+}
+
+// GetCompressor returns the registered compressor by ID
+func GetCompressor(id byte) (Compressor, error) {
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+	if compressor, found := compressors[id]; found {
+		return compressor, nil
+	}
+	return nil, UnsupportedCodecError{"compression", id}
+}
+
+// GetCipher returns the registered cipher by ID
+func GetCipher(id byte) (Cipher, error) {
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+	if cipher, found := ciphers[id]; found {
+		return cipher, nil
+	}
+	return nil, UnsupportedCodecError{"encryption", id}
+}
+
+func init() {
+	RegisterCompressor(COMPRESS_NONE, noneCompressor{})
+	RegisterCompressor(COMPRESS_GZIP, gzipCompressor{})
+	RegisterCompressor(COMPRESS_SNAPPY, snappyCompressor{})
+	RegisterCompressor(COMPRESS_ZSTD, &ZstdCompressor{})
+	RegisterCompressor(COMPRESS_DEFLATE, deflateCompressor{})
+
+	RegisterCipher(ENCRYPT_NONE, noneCipher{})
+	RegisterCipher(ENCRYPT_AES, aesCipher{})
+}
+
+// noneCompressor stores the content without compression
+type noneCompressor struct{}
+
+func (noneCompressor) Name() string                                    { return "none" }
+func (noneCompressor) MaxLevel() int                                   { return 0 }
+func (noneCompressor) Compress(data []byte, level int) ([]byte, error) { return data, nil }
+func (noneCompressor) Decompress(data []byte) ([]byte, error)          { return data, nil }
+
+// gzipCompressor compresses the content with GZIP
+type gzipCompressor struct{}
+
+func (gzipCompressor) Name() string  { return "gzip" }
+func (gzipCompressor) MaxLevel() int { return gzip.BestCompression }
+func (gzipCompressor) Compress(data []byte, level int) ([]byte, error) {
+	return compressLevel(data, level)
+}
+func (gzipCompressor) Decompress(data []byte) ([]byte, error) { return decompress(data) }
+
+// snappyCompressor compresses the content with the Snappy block format.
+// It is much faster than GZIP, but the ratio is worse.
+type snappyCompressor struct{}
+
+func (snappyCompressor) Name() string  { return "snappy" }
+func (snappyCompressor) MaxLevel() int { return 0 }
+func (snappyCompressor) Compress(data []byte, level int) ([]byte, error) {
+	return snappy.Encode(nil, data), nil
+}
+func (snappyCompressor) Decompress(data []byte) ([]byte, error) { return snappy.Decode(nil, data) }
+
+// ZSTD_MAX_LEVEL is the maximum level of the Zstandard compression
+const ZSTD_MAX_LEVEL = 22
+
+// ZstdCompressor compresses the content with Zstandard. It has better ratio
+// than GZIP, mainly on text contents. The WindowSize is the long-range window
+// of the encoder, 0 means the default window of the level. The decompression
+// accepts any window, so it doesn't depend on the WindowSize.
+type ZstdCompressor struct {
+	WindowSize int
+
+	lock     sync.Mutex
+	encoders map[int]*zstd.Encoder
+}
+
+// zstdDecoder is the shared decoder of the Zstandard contents
+var zstdDecoder struct {
+	once    sync.Once
+	decoder *zstd.Decoder
+	err     error
+}
+
+// NewZstdCompressor creates a new ZstdCompressor with the long-range window.
+// The window size should be a power of 2 between 1 KB and zstd.MaxWindowSize.
+func NewZstdCompressor(windowSize int) (*ZstdCompressor, error) {
+	if windowSize < 0 || (windowSize > 0 && (windowSize < zstd.MinWindowSize || windowSize > zstd.MaxWindowSize || windowSize&(windowSize-1) != 0)) {
+		return nil, fmt.Errorf("Invalid window size (%d)!", windowSize)
+	}
+	return &ZstdCompressor{WindowSize: windowSize}, nil
+}
+
+func (this *ZstdCompressor) Name() string  { return "zstd" }
+func (this *ZstdCompressor) MaxLevel() int { return ZSTD_MAX_LEVEL }
+
+func (this *ZstdCompressor) Compress(data []byte, level int) ([]byte, error) {
+	encoder, err := this.encoder(level)
+	if err != nil {
+		return nil, err
+	}
+	return encoder.EncodeAll(data, nil), nil
+}
+
+func (this *ZstdCompressor) Decompress(data []byte) ([]byte, error) {
+	zstdDecoder.once.Do(func() {
+		zstdDecoder.decoder, zstdDecoder.err = zstd.NewReader(nil, zstd.WithDecoderMaxWindow(zstd.MaxWindowSize), zstd.WithDecoderConcurrency(0))
+	})
+	if zstdDecoder.err != nil {
+		return nil, zstdDecoder.err
+	}
+	return zstdDecoder.decoder.DecodeAll(data, nil)
+}
+
+// encoder returns the cached encoder of the level. The encoders can be
+// used concurrently.
+func (this *ZstdCompressor) encoder(level int) (*zstd.Encoder, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if encoder, found := this.encoders[level]; found {
+		return encoder, nil
+	}
+
+	encoderLevel := zstd.SpeedBestCompression
+	if level != LEVEL_DEFAULT {
+		encoderLevel = zstd.EncoderLevelFromZstd(level)
+	}
+
+	options := []zstd.EOption{zstd.WithEncoderLevel(encoderLevel)}
+	if this.WindowSize > 0 {
+		options = append(options, zstd.WithWindowSize(this.WindowSize))
+	}
+
+	encoder, err := zstd.NewWriter(nil, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	if this.encoders == nil {
+		this.encoders = make(map[int]*zstd.Encoder)
+	}
+	this.encoders[level] = encoder
+	return encoder, nil
+}
+
+// deflateCompressor compresses the content with raw DEFLATE. It supports
+// the shared dictionary.
+type deflateCompressor struct{}
+
+func (deflateCompressor) Name() string  { return "deflate" }
+func (deflateCompressor) MaxLevel() int { return flate.BestCompression }
+
+func (this deflateCompressor) Compress(data []byte, level int) ([]byte, error) {
+	return this.CompressDict(data, nil, level)
+}
+
+func (this deflateCompressor) Decompress(data []byte) ([]byte, error) {
+	return this.DecompressDict(data, nil)
+}
+
+func (deflateCompressor) CompressDict(data []byte, dict []byte, level int) ([]byte, error) {
+	if level == LEVEL_DEFAULT {
+		level = flate.BestCompression
+	}
+
+	var b bytes.Buffer
+	w, err := flate.NewWriterDict(&b, level, dict)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func (deflateCompressor) DecompressDict(data []byte, dict []byte) ([]byte, error) {
+	r := flate.NewReaderDict(bytes.NewReader(data), dict)
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// noneCipher stores the content without encryption
+type noneCipher struct{}
+
+func (noneCipher) Name() string                                    { return "none" }
+func (noneCipher) Encrypt(data []byte, key []byte) ([]byte, error) { return data, nil }
+func (noneCipher) Decrypt(data []byte, key []byte) ([]byte, error) { return data, nil }
+
+// aesCipher encrypts the content with AES (CFB mode, random IV)
+type aesCipher struct{}
+
+func (aesCipher) Name() string                                    { return "aes" }
+func (aesCipher) Encrypt(data []byte, key []byte) ([]byte, error) { return encrypt(data, key) }
+func (aesCipher) Decrypt(data []byte, key []byte) ([]byte, error) { return decrypt(data, key) }
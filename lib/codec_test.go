@@ -0,0 +1,223 @@
+package icepacker
+
+import (
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// COMPRESS_TEST is the ID of the test compressor
+const COMPRESS_TEST = 200
+
+// testCompressor wraps the gzip compression with a prefix byte
+type testCompressor struct{}
+
+func (testCompressor) Name() string  { return "test" }
+func (testCompressor) MaxLevel() int { return 9 }
+
+func (testCompressor) Compress(data []byte, level int) ([]byte, error) {
+	res, err := compressLevel(data, level)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{'T'}, res...), nil
+}
+
+func (testCompressor) Decompress(data []byte) ([]byte, error) {
+	if len(data) == 0 || data[0] != 'T' {
+		return nil, errors.New("Invalid test data!")
+	}
+	return decompress(data[1:])
+}
+
+func TestCodecRegistry(t *testing.T) {
+
+	Convey("Should give the built-in codecs", t, func() {
+
+		compressor, err := GetCompressor(COMPRESS_GZIP)
+		So(err, ShouldBeNil)
+		So(compressor.Name(), ShouldEqual, "gzip")
+
+		compressor, err = GetCompressor(COMPRESS_SNAPPY)
+		So(err, ShouldBeNil)
+		So(compressor.Name(), ShouldEqual, "snappy")
+
+		cipher, err := GetCipher(ENCRYPT_AES)
+		So(err, ShouldBeNil)
+		So(cipher.Name(), ShouldEqual, "aes")
+	})
+
+	Convey("Should give error for unknown codecs", t, func() {
+
+		_, err := GetCompressor(123)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "unsupported codec 123 (compression)")
+
+		_, err = GetCipher(123)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "unsupported codec 123 (encryption)")
+
+		_, err = TransformPack([]byte("data"), 123, ENCRYPT_NONE, nil)
+		So(err, ShouldNotBeNil)
+
+		_, err = CreateBundle("testdata/packed/unknown.pack", BundleSettings{Compression: 123})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Should ignore the level if the compression has no levels", t, func() {
+
+		target, _ := filepath.Abs("testdata/packed/snappy.pack")
+		bundle, err := CreateBundle(target, BundleSettings{Compression: COMPRESS_SNAPPY, Level: 5})
+		So(err, ShouldBeNil)
+		bundle.Close()
+		os.Remove(target)
+	})
+
+	Convey("Should compress with zstd levels & long-range window", t, func() {
+
+		// Text which repeats after a long distance
+		random := rand.New(rand.NewSource(1))
+		part := make([]byte, 4<<20)
+		random.Read(part)
+		origData := append(append([]byte{}, part...), part...)
+
+		compressor, err := NewZstdCompressor(16 << 20)
+		So(err, ShouldBeNil)
+
+		compressed, err := compressor.Compress(origData, 3)
+		So(err, ShouldBeNil)
+		So(len(compressed), ShouldBeLessThan, len(part)+len(part)/10)
+
+		// Decompress with the registered compressor
+		defaultCompressor, _ := GetCompressor(COMPRESS_ZSTD)
+		res, err := defaultCompressor.Decompress(compressed)
+		So(err, ShouldBeNil)
+		So(res, ShouldResemble, origData)
+
+		for _, level := range []int{LEVEL_DEFAULT, 1, ZSTD_MAX_LEVEL} {
+			compressed, err := defaultCompressor.Compress([]byte("Original plain data, original plain data"), level)
+			So(err, ShouldBeNil)
+			res, err := defaultCompressor.Decompress(compressed)
+			So(err, ShouldBeNil)
+			So(string(res), ShouldEqual, "Original plain data, original plain data")
+		}
+
+		_, err = NewZstdCompressor(1000)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "Invalid window size (1000)!")
+
+		_, err = CreateBundle("testdata/packed/zstd.pack", BundleSettings{Compression: COMPRESS_ZSTD, Level: ZSTD_MAX_LEVEL + 1})
+		So(err, ShouldNotBeNil)
+
+		// Should check the window size of the settings
+		_, err = CreateBundle("testdata/packed/zstd.pack", BundleSettings{Compression: COMPRESS_ZSTD, WindowSize: 1000})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "Invalid window size (1000)!")
+
+		// Should pack with the window of the settings
+		filename := "testdata/packed/zstd-long.pack"
+		defer os.Remove(filename)
+		bundle, err := CreateBundle(filename, BundleSettings{Compression: COMPRESS_ZSTD, WindowSize: 16 << 20})
+		So(err, ShouldBeNil)
+		packCompressor, err := bundle.compressor(COMPRESS_ZSTD)
+		So(err, ShouldBeNil)
+		So(packCompressor.(*ZstdCompressor).WindowSize, ShouldEqual, 16<<20)
+		source, _ := filepath.Abs("testdata/simple/file1.txt")
+		_, err = bundle.AddFile("file1.txt", source)
+		So(err, ShouldBeNil)
+		So(bundle.Finalize(), ShouldBeNil)
+		So(bundle.Close(), ShouldBeNil)
+
+		// Should keep the registered compressor
+		So(defaultCompressor.(*ZstdCompressor).WindowSize, ShouldEqual, 0)
+
+		bundle, err = OpenBundle(filename, nil)
+		So(err, ShouldBeNil)
+		defer bundle.Close()
+		res, err = bundle.ReadFileFromPath("file1.txt")
+		So(err, ShouldBeNil)
+		So(string(res), ShouldEqual, "file1")
+	})
+
+	Convey("Should compress with a shared dictionary", t, func() {
+
+		dict := []byte(`{"id": 1, "name": "Item 1", "tags": ["small", "json", "file"], "enabled": true}`)
+		data := []byte(`{"id": 2, "name": "Item 2", "tags": ["small", "json", "file"], "enabled": false}`)
+
+		compressor, _ := GetCompressor(COMPRESS_DEFLATE)
+		dictCompressor, ok := compressor.(DictCompressor)
+		So(ok, ShouldBeTrue)
+
+		compressed, err := dictCompressor.CompressDict(data, dict, LEVEL_DEFAULT)
+		So(err, ShouldBeNil)
+		plainCompressed, _ := compressor.Compress(data, LEVEL_DEFAULT)
+		So(len(compressed), ShouldBeLessThan, len(plainCompressed)/2)
+
+		res, err := dictCompressor.DecompressDict(compressed, dict)
+		So(err, ShouldBeNil)
+		So(string(res), ShouldEqual, string(data))
+
+		_, err = transformUnpackDict(compressed, COMPRESS_GZIP, dict, ENCRYPT_NONE, nil)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "The gzip compression doesn't support dictionary!")
+	})
+
+	Convey("Should pack & unpack with a registered compressor", t, func() {
+
+		RegisterCompressor(COMPRESS_TEST, testCompressor{})
+		So(CompressionName(COMPRESS_TEST), ShouldEqual, "test")
+
+		source, _ := filepath.Abs("testdata/simple")
+		target, _ := filepath.Abs("testdata/packed/codec.pack")
+		unTarget, _ := filepath.Abs("testdata/unpacked/codec")
+
+		result := Pack(PackSettings{
+			SourceDir:      source,
+			TargetFilename: target,
+			Compression:    COMPRESS_TEST,
+			Encryption:     ENCRYPT_AES,
+			Cipher:         NewCipherSettings("PackSecretKey"),
+		})
+		So(result.Err, ShouldBeNil)
+
+		bundle, err := OpenBundle(target, HashingKey(NewCipherSettings("PackSecretKey")))
+		So(err, ShouldBeNil)
+		So(bundle.Header.Compress, ShouldEqual, COMPRESS_TEST)
+
+		item, err := bundle.GetItemByPath("dir1/file3.txt")
+		So(err, ShouldBeNil)
+		So(item.Compress, ShouldEqual, COMPRESS_TEST)
+		bundle.Close()
+
+		result = Unpack(UnpackSettings{
+			PackFileName: target,
+			TargetDir:    unTarget,
+			Cipher:       NewCipherSettings("PackSecretKey"),
+		})
+		So(result.Err, ShouldBeNil)
+		So(result.FileCount, ShouldEqual, 8)
+
+		content, _ := ioutil.ReadFile(filepath.Join(unTarget, "dir1", "file3.txt"))
+		origContent, _ := ioutil.ReadFile(filepath.Join(source, "dir1", "file3.txt"))
+		So(string(content), ShouldEqual, string(origContent))
+
+		Convey("should give error on open, if the codec is not registered", func() {
+
+			codecLock.Lock()
+			delete(compressors, COMPRESS_TEST)
+			codecLock.Unlock()
+
+			_, err := OpenBundle(target, HashingKey(NewCipherSettings("PackSecretKey")))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "unsupported codec 200 (compression)")
+		})
+
+		os.Remove(target)
+		os.RemoveAll(unTarget)
+	})
+}
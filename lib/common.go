@@ -2,6 +2,7 @@ package icepacker
 
 import (
 	"encoding/binary"
+	"path"
 	"runtime"
 	"strings"
 )
@@ -15,6 +16,14 @@ const MAGIC_SIZE = 5
 
 const VERSION_1 = 1
 
+// VERSION_2 records the used compression in every FAT item, supports the
+// solid segments and the framed (seekable) entries, and stores the FAT in
+// path-sorted pages (FATIndex)
+const VERSION_2 = 2
+
+// VERSION is the version of the created bundles
+const VERSION = VERSION_2
+
 var ByteOrder = binary.LittleEndian
 
 // Encryption enum constants
@@ -27,8 +36,72 @@ const (
 const (
 	COMPRESS_NONE = iota
 	COMPRESS_GZIP
+	COMPRESS_SNAPPY
+	COMPRESS_ZSTD
+	COMPRESS_DEFLATE
 )
 
+// SOLID_SEGMENT_SIZE is the maximum size of the uncompressed content of a
+// solid segment. The smaller files are compressed together in segments.
+const SOLID_SEGMENT_SIZE = 1 << 20
+
+// DICT_SIZE is the maximum size of the shared compression dictionary
+// (the window of the deflate compression)
+const DICT_SIZE = 32 << 10
+
+// DICT_FILE_SIZE is the limit of the size of files which are compressed
+// with the shared dictionary
+const DICT_FILE_SIZE = 64 << 10
+
+// LEVEL_DEFAULT means the default level of the compression (gzip, zstd: best compression)
+const LEVEL_DEFAULT = 0
+
+// CompressionName returns the name of the registered compression
+func CompressionName(compression byte) string {
+	if compressor, err := GetCompressor(compression); err == nil {
+		return compressor.Name()
+	}
+	return "unknown"
+}
+
+// CompressRule selects the compression of the files which match the
+// glob pattern. If the pattern doesn't contain '/', it is matched to the
+// name of the file, otherwise to the relative path.
+type CompressRule struct {
+	Pattern     string
+	Compression byte
+}
+
+// Match checks the relative path (with '/' separators) matches the rule
+func (rule CompressRule) Match(filepath string) bool {
+	name := filepath
+	if !strings.Contains(rule.Pattern, "/") {
+		name = path.Base(filepath)
+	}
+	matched, _ := path.Match(rule.Pattern, name)
+	return matched
+}
+
+// CompressedFileRules contains rules to store the commonly already-compressed
+// files without compression
+var CompressedFileRules = []CompressRule{
+	{"*.7z", COMPRESS_NONE},
+	{"*.bz2", COMPRESS_NONE},
+	{"*.gif", COMPRESS_NONE},
+	{"*.gz", COMPRESS_NONE},
+	{"*.jpeg", COMPRESS_NONE},
+	{"*.jpg", COMPRESS_NONE},
+	{"*.mp3", COMPRESS_NONE},
+	{"*.mp4", COMPRESS_NONE},
+	{"*.png", COMPRESS_NONE},
+	{"*.rar", COMPRESS_NONE},
+	{"*.webm", COMPRESS_NONE},
+	{"*.webp", COMPRESS_NONE},
+	{"*.woff2", COMPRESS_NONE},
+	{"*.xz", COMPRESS_NONE},
+	{"*.zip", COMPRESS_NONE},
+}
+
 // Fixing filepath on Windows to support longer filepath than 255 bytes.
 // More information: https://msdn.microsoft.com/en-us/library/aa365247(VS.85).aspx
 func FixPath(path string) string {
@@ -35,3 +35,51 @@ func TestFixPath(t *testing.T) {
 	})
 
 }
+
+func TestCompressRule(t *testing.T) {
+
+	Convey("Should match the files", t, func() {
+
+		var tests = []struct {
+			pattern  string
+			path     string
+			expected bool
+		}{
+			{"*.mp3", "song.mp3", true},
+			{"*.mp3", "music/rock/song.mp3", true},
+			{"*.mp3", "music/song.mp3.txt", false},
+			{"music/*.mp3", "music/song.mp3", true},
+			{"music/*.mp3", "music/rock/song.mp3", false},
+			{"music/*/*", "music/rock/song.mp3", true},
+			{"song.???", "a/song.wav", true},
+		}
+
+		for _, test := range tests {
+			So(CompressRule{test.pattern, COMPRESS_NONE}.Match(test.path), ShouldEqual, test.expected)
+		}
+	})
+
+	Convey("Should select the compression by the first matched rule", t, func() {
+
+		settings := BundleSettings{
+			Compression: COMPRESS_GZIP,
+			Rules: []CompressRule{
+				{"logs/*", COMPRESS_GZIP},
+				{"*.png", COMPRESS_NONE},
+			},
+		}
+
+		So(settings.CompressionOf("index.html"), ShouldEqual, COMPRESS_GZIP)
+		So(settings.CompressionOf("img/icon.png"), ShouldEqual, COMPRESS_NONE)
+		So(settings.CompressionOf("logs/icon.png"), ShouldEqual, COMPRESS_GZIP)
+	})
+
+	Convey("Should give the name of compression", t, func() {
+		So(CompressionName(COMPRESS_NONE), ShouldEqual, "none")
+		So(CompressionName(COMPRESS_GZIP), ShouldEqual, "gzip")
+		So(CompressionName(COMPRESS_SNAPPY), ShouldEqual, "snappy")
+		So(CompressionName(COMPRESS_ZSTD), ShouldEqual, "zstd")
+		So(CompressionName(COMPRESS_DEFLATE), ShouldEqual, "deflate")
+		So(CompressionName(99), ShouldEqual, "unknown")
+	})
+}
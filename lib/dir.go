@@ -0,0 +1,303 @@
+package icepacker
+
+import (
+	"errors"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DirEntry is an entry of a directory in the bundle. The directories are
+// not stored in the bundle, they are synthesized from the paths of files.
+type DirEntry struct {
+	// The base name of the entry ("." on the root directory)
+	Name string
+
+	// The full path of the entry ("." on the root directory)
+	Path string
+
+	IsDir bool
+
+	// The FAT item of the file (nil on directories)
+	Item *FATItem
+}
+
+// pathIndex is the path map & the directory tree of the loaded FAT items
+type pathIndex struct {
+	lock  sync.Mutex
+	items map[string]int
+	dirs  map[string][]DirEntry
+}
+
+// errStopWalk stops the walking without error
+var errStopWalk = errors.New("stop walk")
+
+// cleanPath returns the path in the form of FAT items ("" is the root directory)
+func cleanPath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// rootEntry returns the entry of the root directory
+func rootEntry() DirEntry {
+	return DirEntry{Name: ".", Path: ".", IsDir: true}
+}
+
+// fileEntry returns the entry of the file
+func fileEntry(item FATItem) DirEntry {
+	return DirEntry{Name: path.Base(item.Path), Path: item.Path, Item: &item}
+}
+
+// dirEntry returns the entry of the synthesized directory
+func dirEntry(dir string) DirEntry {
+	return DirEntry{Name: path.Base(dir), Path: dir, IsDir: true}
+}
+
+// sortEntries sorts the entries by path
+func sortEntries(entries []DirEntry) []DirEntry {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries
+}
+
+// pathIndex returns the path map & the directory tree of the FAT items. It is
+// built on the first call and rebuilt after the items are changed.
+func (this *BundleFile) pathIndex() (map[string]int, map[string][]DirEntry) {
+	this.paths.lock.Lock()
+	defer this.paths.lock.Unlock()
+
+	if this.paths.items != nil {
+		return this.paths.items, this.paths.dirs
+	}
+
+	items := make(map[string]int)
+	children := map[string]map[string]DirEntry{"": {}}
+
+	for i, item := range this.FAT.Items {
+		if _, found := items[item.Path]; found {
+			continue
+		}
+		items[item.Path] = i
+
+		// Add the file & the parent directories to the tree
+		entry := fileEntry(item)
+		for {
+			dir := path.Dir(entry.Path)
+			if dir == "." {
+				dir = ""
+			}
+
+			if children[dir] == nil {
+				children[dir] = make(map[string]DirEntry)
+			}
+			if _, found := children[dir][entry.Name]; found {
+				break
+			}
+			children[dir][entry.Name] = entry
+
+			if dir == "" {
+				break
+			}
+			entry = dirEntry(dir)
+		}
+	}
+
+	dirs := make(map[string][]DirEntry, len(children))
+	for dir, entries := range children {
+		list := make([]DirEntry, 0, len(entries))
+		for _, entry := range entries {
+			list = append(list, entry)
+		}
+		dirs[dir] = sortEntries(list)
+	}
+
+	this.paths.items = items
+	this.paths.dirs = dirs
+	return items, dirs
+}
+
+// resetPathIndex drops the path map & the directory tree after the items are changed
+func (this *BundleFile) resetPathIndex() {
+	this.paths.lock.Lock()
+	defer this.paths.lock.Unlock()
+
+	this.paths.items = nil
+	this.paths.dirs = nil
+}
+
+// walkPrefix calls `fn` for the items (sorted by path) of the paged FAT index
+// which paths begin with the prefix
+func (this *BundleFile) walkPrefix(prefix string, fn func(item FATItem) error) error {
+	pages := this.index.Pages
+	first := sort.Search(len(pages), func(i int) bool { return pages[i].First >= prefix }) - 1
+	if first < 0 {
+		first = 0
+	}
+
+	for i := first; i < len(pages); i++ {
+		items, err := this.readPage(i)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			if item.Path < prefix {
+				continue
+			}
+			if !strings.HasPrefix(item.Path, prefix) {
+				return nil
+			}
+			if err := fn(item.item()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Stat returns the entry of the file or the directory
+func (this *BundleFile) Stat(name string) (*DirEntry, error) {
+	p := cleanPath(name)
+	if p == "" {
+		entry := rootEntry()
+		return &entry, nil
+	}
+
+	if !this.lazy {
+		items, dirs := this.pathIndex()
+		if i, found := items[p]; found {
+			entry := fileEntry(this.FAT.Items[i])
+			return &entry, nil
+		}
+		if _, found := dirs[p]; found {
+			entry := dirEntry(p)
+			return &entry, nil
+		}
+		return nil, errors.New("File not found! Path: " + name)
+	}
+
+	if item, err := this.lookupItem(p); err == nil {
+		entry := fileEntry(*item)
+		return &entry, nil
+	}
+
+	// The directory exists, if a file is in it
+	found := false
+	err := this.walkPrefix(p+"/", func(item FATItem) error {
+		found = true
+		return errStopWalk
+	})
+	if err != nil && err != errStopWalk {
+		return nil, err
+	}
+	if !found {
+		return nil, errors.New("File not found! Path: " + name)
+	}
+	entry := dirEntry(p)
+	return &entry, nil
+}
+
+// ReadDir returns the entries of the directory sorted by name. The
+// subdirectories are synthesized from the paths of files.
+func (this *BundleFile) ReadDir(name string) ([]DirEntry, error) {
+	p := cleanPath(name)
+
+	var entries []DirEntry
+	if !this.lazy {
+		_, dirs := this.pathIndex()
+		entries = dirs[p]
+	} else {
+		prefix := ""
+		if p != "" {
+			prefix = p + "/"
+		}
+
+		children := make(map[string]bool)
+		err := this.walkPrefix(prefix, func(item FATItem) error {
+			rest := item.Path[len(prefix):]
+			if i := strings.Index(rest, "/"); i >= 0 {
+				if !children[rest[:i]] {
+					children[rest[:i]] = true
+					entries = append(entries, dirEntry(prefix+rest[:i]))
+				}
+			} else if !children[rest] {
+				children[rest] = true
+				entries = append(entries, fileEntry(item))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		entries = sortEntries(entries)
+	}
+
+	if entries == nil {
+		if p == "" {
+			return []DirEntry{}, nil
+		}
+
+		entry, err := this.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !entry.IsDir {
+			return nil, errors.New("Not a directory! Path: " + name)
+		}
+	}
+
+	res := make([]DirEntry, len(entries))
+	copy(res, entries)
+	return res, nil
+}
+
+// Glob returns the entries of files & directories which paths match the
+// pattern (see path.Match), sorted by path
+func (this *BundleFile) Glob(pattern string) ([]DirEntry, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	entries := []DirEntry{}
+	found := make(map[string]bool)
+	match := func(entry DirEntry) {
+		if !found[entry.Path] {
+			if ok, _ := path.Match(pattern, entry.Path); ok {
+				found[entry.Path] = true
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	if !this.lazy {
+		items, dirs := this.pathIndex()
+		for _, i := range items {
+			match(fileEntry(this.FAT.Items[i]))
+		}
+		for dir := range dirs {
+			if dir != "" {
+				match(dirEntry(dir))
+			}
+		}
+		return sortEntries(entries), nil
+	}
+
+	// The matched paths begin with the literal part of the pattern
+	prefix := pattern
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		prefix = pattern[:i]
+	}
+
+	err := this.walkPrefix(prefix, func(item FATItem) error {
+		match(fileEntry(item))
+		for dir := path.Dir(item.Path); dir != "."; dir = path.Dir(dir) {
+			if found[dir] {
+				break
+			}
+			match(dirEntry(dir))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sortEntries(entries), nil
+}
@@ -0,0 +1,130 @@
+package icepacker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func entryPaths(entries []DirEntry) []string {
+	res := []string{}
+	for _, entry := range entries {
+		res = append(res, entry.Path)
+	}
+	return res
+}
+
+func TestDirListing(t *testing.T) {
+
+	source, _ := filepath.Abs("testdata/simple")
+	target, _ := filepath.Abs("testdata/packed/dir.pack")
+	defer os.Remove(target)
+
+	result := Pack(PackSettings{
+		SourceDir:      source,
+		TargetFilename: target,
+		Compression:    COMPRESS_GZIP,
+	})
+
+	opens := map[string]func(string, []byte) (*BundleFile, error){
+		"OpenBundle":     OpenBundle,
+		"OpenBundleLazy": OpenBundleLazy,
+	}
+
+	for name, open := range opens {
+
+		Convey("Should list the directories with "+name, t, func() {
+			So(result.Err, ShouldBeNil)
+
+			bundle, err := open(target, nil)
+			So(err, ShouldBeNil)
+			defer bundle.Close()
+
+			{
+				// Should stat the files & the synthesized directories
+				entry, err := bundle.Stat("dir1/file3.txt")
+				So(err, ShouldBeNil)
+				So(entry.Name, ShouldEqual, "file3.txt")
+				So(entry.IsDir, ShouldBeFalse)
+				So(entry.Item.OrigSize, ShouldEqual, 2382)
+
+				entry, err = bundle.Stat("/dir2/")
+				So(err, ShouldBeNil)
+				So(*entry, ShouldResemble, DirEntry{Name: "dir2", Path: "dir2", IsDir: true})
+
+				entry, err = bundle.Stat(".")
+				So(err, ShouldBeNil)
+				So(entry.IsDir, ShouldBeTrue)
+
+				_, err = bundle.Stat("dir")
+				So(err.Error(), ShouldEqual, "File not found! Path: dir")
+			}
+
+			{
+				// Should read the entries of the directories
+				entries, err := bundle.ReadDir("")
+				So(err, ShouldBeNil)
+				So(entryPaths(entries), ShouldResemble, []string{".hidden", "dir1", "dir2", "empty.txt", "file1.txt", "file2.txt"})
+				So(entries[1].IsDir, ShouldBeTrue)
+				So(entries[3].IsDir, ShouldBeFalse)
+
+				entries, err = bundle.ReadDir("dir2")
+				So(err, ShouldBeNil)
+				So(entryPaths(entries), ShouldResemble, []string{"dir2/icon-same.png", "dir2/index.html"})
+				So(entries[1].Name, ShouldEqual, "index.html")
+
+				_, err = bundle.ReadDir("file1.txt")
+				So(err.Error(), ShouldEqual, "Not a directory! Path: file1.txt")
+				_, err = bundle.ReadDir("dir3")
+				So(err.Error(), ShouldEqual, "File not found! Path: dir3")
+			}
+
+			{
+				// Should find the entries by pattern
+				entries, err := bundle.Glob("dir*/*.png")
+				So(err, ShouldBeNil)
+				So(entryPaths(entries), ShouldResemble, []string{"dir1/icon1.png", "dir2/icon-same.png"})
+
+				entries, err = bundle.Glob("dir?")
+				So(err, ShouldBeNil)
+				So(entryPaths(entries), ShouldResemble, []string{"dir1", "dir2"})
+
+				entries, err = bundle.Glob("dir2/index.html")
+				So(err, ShouldBeNil)
+				So(entryPaths(entries), ShouldResemble, []string{"dir2/index.html"})
+
+				entries, err = bundle.Glob("*.pdf")
+				So(err, ShouldBeNil)
+				So(entries, ShouldBeEmpty)
+
+				_, err = bundle.Glob("[")
+				So(err, ShouldNotBeNil)
+			}
+		})
+	}
+
+	Convey("Should rebuild the path map after adding files", t, func() {
+		target, _ := filepath.Abs("testdata/packed/dir-add.pack")
+		defer os.Remove(target)
+
+		bundle, err := CreateBundle(target, BundleSettings{})
+		So(err, ShouldBeNil)
+		defer bundle.Close()
+
+		bundle.AddFile("a/file1.txt", filepath.Join(source, "file1.txt"))
+		entries, err := bundle.ReadDir("a")
+		So(err, ShouldBeNil)
+		So(len(entries), ShouldEqual, 1)
+
+		bundle.AddFile("a/b/file2.txt", filepath.Join(source, "file2.txt"))
+		entries, err = bundle.ReadDir("a")
+		So(err, ShouldBeNil)
+		So(entryPaths(entries), ShouldResemble, []string{"a/b", "a/file1.txt"})
+
+		item, err := bundle.GetItemByPath("a/b/file2.txt")
+		So(err, ShouldBeNil)
+		So(item.OrigSize, ShouldEqual, 14)
+	})
+}
@@ -11,6 +11,10 @@ type FAT struct {
 	Count int64     `json:"count"`
 	Size  int64     `json:"size"`
 	Items []FATItem `json:"items"`
+
+	// The shared compression dictionary (at DictOffset with DictSize)
+	DictOffset int64 `json:"dictOffset,omitempty"`
+	DictSize   int64 `json:"dictSize,omitempty"`
 }
 
 // FATItem is a structure for file item in FAT
@@ -23,6 +27,20 @@ type FATItem struct {
 	MTime    int64    `json:"mTime"`
 	Mode     uint32   `json:"mode"`
 	Perm     uint32   `json:"perm"`
+	Compress byte     `json:"compress,omitempty"`
+
+	// The content is in a solid segment (at Offset with Size) from SolidOffset
+	Solid       bool  `json:"solid,omitempty"`
+	SolidOffset int64 `json:"solidOffset,omitempty"`
+
+	// The content is compressed with the shared dictionary
+	Dict bool `json:"dict,omitempty"`
+
+	// The sizes of the independently decodable frames (seek table) of the large content
+	Frames []int64 `json:"frames,omitempty"`
+
+	// The size of the decoded frames, if it isn't FRAME_SIZE
+	FrameSize int64 `json:"frameSize,omitempty"`
 }
 
 // String Convert the whole FAT to string
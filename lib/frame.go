@@ -0,0 +1,379 @@
+package icepacker
+
+import (
+	"crypto/sha512"
+	"errors"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// FRAME_SIZE is the size of the uncompressed content of frames. The large
+// entries are stored in independently decodable frames, so a range of the
+// entry can be read without decoding the whole entry.
+const FRAME_SIZE = 1 << 20
+
+// frameSize returns the size of the frames of the compression. The frames
+// are independent, so with the long-range window of the Zstandard compression
+// the frames are as large as the window, otherwise the window has no effect.
+func (this *BundleFile) frameSize(compression byte) int {
+	if compression == COMPRESS_ZSTD && this.Settings.WindowSize > FRAME_SIZE {
+		return this.Settings.WindowSize
+	}
+	return FRAME_SIZE
+}
+
+// itemFrameSize returns the size of the decoded frames of the item
+func itemFrameSize(item FATItem) int64 {
+	if item.FrameSize > 0 {
+		return item.FrameSize
+	}
+	return FRAME_SIZE
+}
+
+// FRAME_BUFFER_SIZE is the max size of the frames which are read and
+// transformed ahead by writeFrames
+const FRAME_BUFFER_SIZE = 64 << 20
+
+// transformedFrame records a compressed & encrypted frame
+type transformedFrame struct {
+	frame []byte
+	err   error
+}
+
+// writeFrames streams the content of the reader to the end of the data block.
+// The content is read in frames, which are compressed & encrypted concurrently
+// and are written in order, so only a few frames are kept in the memory. If
+// the first frame is not smaller with compression, the frames are stored
+// without compression. The hash, the size, the offset, the seek table and the
+// used compression are set to the item. The not transformed content is stored
+// without frames.
+func (this *BundleFile) writeFrames(item *FATItem, reader io.Reader, compression byte) error {
+	compressor, err := this.compressor(compression)
+	if err != nil {
+		return err
+	}
+	cipher, err := GetCipher(this.Settings.Encryption)
+	if err != nil {
+		return err
+	}
+
+	frameSize := this.frameSize(compression)
+	ahead := 2 * runtime.NumCPU()
+	if ahead > FRAME_BUFFER_SIZE/frameSize {
+		ahead = FRAME_BUFFER_SIZE / frameSize
+	}
+	if ahead < 1 {
+		ahead = 1
+	}
+
+	hash := sha512.New()
+	origSize := int64(0)
+
+	transform := func(frame []byte, compress bool, result chan transformedFrame) {
+		var err error
+		if compress {
+			frame, err = compressor.Compress(frame, this.Settings.Level)
+		}
+		if err == nil {
+			frame, err = cipher.Encrypt(frame, this.Settings.CipherKey)
+		}
+		result <- transformedFrame{frame, err}
+	}
+
+	// Read the frames & transform them in the background. The queue keeps
+	// the order of the frames and limits the count of the frames in memory.
+	queue := make(chan chan transformedFrame, ahead)
+	stop := make(chan bool)
+	defer close(stop)
+
+	go func() {
+		defer close(queue)
+
+		for i := 0; ; i++ {
+			frame := make([]byte, frameSize)
+			n, err := io.ReadFull(reader, frame)
+			if err == io.EOF {
+				return
+			}
+
+			result := make(chan transformedFrame, 1)
+			select {
+			case queue <- result:
+			case <-stop:
+				return
+			}
+
+			if err != nil && err != io.ErrUnexpectedEOF {
+				result <- transformedFrame{nil, err}
+				return
+			}
+			frame = frame[:n]
+			hash.Write(frame)
+			origSize += int64(n)
+
+			// The first frame decides the compression
+			if i == 0 && compression != COMPRESS_NONE {
+				compressed, err := compressor.Compress(frame, this.Settings.Level)
+				if err != nil {
+					result <- transformedFrame{nil, err}
+					return
+				}
+				if len(compressed) < len(frame) {
+					frame = compressed
+				} else {
+					compression = COMPRESS_NONE
+				}
+				go transform(frame, false, result)
+			} else {
+				go transform(frame, compression != COMPRESS_NONE, result)
+			}
+
+			if n < frameSize {
+				return
+			}
+		}
+	}()
+
+	// Write the frames in order
+	offset := this.DataBaseOffset + this.FAT.Size
+	size := int64(0)
+	frames := []int64{}
+	for result := range queue {
+		transformed := <-result
+		if transformed.err != nil {
+			return transformed.err
+		}
+
+		_, err = this.writer.WriteAt(transformed.frame, offset+size)
+		if err != nil {
+			return err
+		}
+		size += int64(len(transformed.frame))
+		frames = append(frames, int64(len(transformed.frame)))
+	}
+
+	if origSize == 0 {
+		return errors.New("The file is truncated while packing! Path: " + item.Path)
+	}
+
+	item.Offset = this.FAT.Size
+	item.Size = size
+	item.OrigSize = origSize
+	copy(item.Hash[:], hash.Sum(nil))
+	item.Compress = compression
+	item.Frames = nil
+	item.FrameSize = 0
+	if compression != COMPRESS_NONE || this.Settings.Encryption != ENCRYPT_NONE {
+		item.Frames = frames
+		if frameSize != FRAME_SIZE {
+			item.FrameSize = int64(frameSize)
+		}
+	}
+
+	this.FAT.Size += size
+	return nil
+}
+
+// frameOffsets returns the offsets of the frames in the blob of the item
+func frameOffsets(item FATItem) []int64 {
+	offsets := make([]int64, len(item.Frames)+1)
+	for i, size := range item.Frames {
+		offsets[i+1] = offsets[i] + size
+	}
+	return offsets
+}
+
+// frameLength returns the length of the decoded i-th frame of the item
+func frameLength(item FATItem, i int) int {
+	frameSize := itemFrameSize(item)
+	end := int64(i+1) * frameSize
+	if end > item.OrigSize {
+		end = item.OrigSize
+	}
+	return int(end - int64(i)*frameSize)
+}
+
+// checkFrames checks that the seek table of the item covers the content
+func checkFrames(item FATItem) error {
+	frameSize := itemFrameSize(item)
+	if int64(len(item.Frames)) != (item.OrigSize+frameSize-1)/frameSize {
+		return errors.New("Invalid seek table! Path: " + item.Path)
+	}
+	return nil
+}
+
+// decodeFrame decrypts & decompresses a frame of the item
+func (this *BundleFile) decodeFrame(item FATItem, frame []byte) ([]byte, error) {
+	return TransformUnpack(frame, item.Compress, this.Settings.Encryption, this.Settings.CipherKey)
+}
+
+// readFrames decodes the frames of the blob concurrently
+func (this *BundleFile) readFrames(item FATItem, blob []byte) ([]byte, error) {
+	if err := checkFrames(item); err != nil {
+		return nil, err
+	}
+	offsets := frameOffsets(item)
+	if offsets[len(item.Frames)] != int64(len(blob)) {
+		return nil, errors.New("Invalid seek table! Path: " + item.Path)
+	}
+
+	res := make([]byte, item.OrigSize)
+	errs := make([]error, len(item.Frames))
+
+	parallel(len(item.Frames), func(i int) {
+		frame, err := this.decodeFrame(item, blob[offsets[i]:offsets[i+1]])
+		if err == nil && len(frame) != frameLength(item, i) {
+			err = errors.New("Invalid frame! Path: " + item.Path)
+		}
+		if err != nil {
+			errs[i] = err
+			return
+		}
+		copy(res[int64(i)*itemFrameSize(item):], frame)
+	})
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+// OpenReaderAt returns an io.ReaderAt of the content of the file. On the
+// framed entries it decodes only the frames which cover the requested range.
+// The returned reader can be used concurrently.
+func (this *BundleFile) OpenReaderAt(path string) (io.ReaderAt, error) {
+	item, err := this.GetItemByPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return this.itemReader(*item), nil
+}
+
+// ItemReaderAt returns an io.ReaderAt of the content of the item, like OpenReaderAt
+func (this *BundleFile) ItemReaderAt(item FATItem) io.ReaderAt {
+	return this.itemReader(item)
+}
+
+// itemReader returns the entryReader of the content of the item
+func (this *BundleFile) itemReader(item FATItem) *entryReader {
+	return &entryReader{bundle: this, item: item, offsets: frameOffsets(item), frame: -1}
+}
+
+// entryReader is an io.ReaderAt of a file of the bundle
+type entryReader struct {
+	bundle  *BundleFile
+	item    FATItem
+	offsets []int64
+
+	// The last decoded frame (or the whole content of a not framed entry)
+	lock    sync.Mutex
+	frame   int
+	content []byte
+}
+
+// ReadAt reads len(p) bytes from the content of the file at offset `off`
+func (this *entryReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("Negative offset!")
+	}
+	if off >= this.item.OrigSize {
+		return 0, io.EOF
+	}
+
+	if this.stored() {
+		return this.readStored(p, off)
+	}
+
+	n := 0
+	for n < len(p) && off < this.item.OrigSize {
+		chunk, chunkOffset, err := this.chunkAt(off)
+		if err != nil {
+			return n, err
+		}
+
+		copied := copy(p[n:], chunk[off-chunkOffset:])
+		n += copied
+		off += int64(copied)
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// stored returns true if the content is stored without transformation
+func (this *entryReader) stored() bool {
+	item := this.item
+	return item.Frames == nil && !item.Solid && !item.Dict && item.Compress == COMPRESS_NONE && this.bundle.Settings.Encryption == ENCRYPT_NONE
+}
+
+// readStored reads the not transformed content directly from the bundle to p
+func (this *entryReader) readStored(p []byte, off int64) (int, error) {
+	size := len(p)
+	if remaining := this.item.OrigSize - off; int64(size) > remaining {
+		size = int(remaining)
+	}
+
+	n, err := this.bundle.readRawAt(p[:size], this.bundle.DataBaseOffset+this.item.Offset+off)
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// chunkAt returns the decoded chunk of the content which contains the
+// offset, and the offset of the chunk
+func (this *entryReader) chunkAt(off int64) ([]byte, int64, error) {
+	item := this.item
+	bundle := this.bundle
+
+	frame := 0
+	if item.Frames != nil {
+		frame = int(off / itemFrameSize(item))
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.frame != frame {
+		var content []byte
+		var err error
+
+		if item.Frames != nil {
+			if err := checkFrames(item); err != nil {
+				return nil, 0, err
+			}
+
+			// Read & decode only the frame
+			var blob []byte
+			blob, err = bundle.readRaw(bundle.DataBaseOffset+item.Offset+this.offsets[frame], item.Frames[frame])
+			if err != nil {
+				return nil, 0, err
+			}
+			content, err = bundle.decodeFrame(item, blob)
+			if err == nil && len(content) != frameLength(item, frame) {
+				err = errors.New("Invalid frame! Path: " + item.Path)
+			}
+		} else {
+			// Small entry: decode the whole content
+			content, err = bundle.ReadFile(item)
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		this.frame = frame
+		this.content = content
+	}
+
+	chunkOffset := int64(frame) * itemFrameSize(item)
+	if off-chunkOffset >= int64(len(this.content)) {
+		return nil, 0, errors.New("Invalid frame! Path: " + item.Path)
+	}
+	return this.content, chunkOffset, nil
+}
@@ -0,0 +1,394 @@
+package icepacker
+
+import (
+	"crypto/sha512"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFramedEntries(t *testing.T) {
+
+	// Compressible content which is larger than 3 frames
+	random := rand.New(rand.NewSource(1))
+	origData := make([]byte, 3*FRAME_SIZE+12345)
+	for i := range origData {
+		origData[i] = "abcdefgh"[random.Intn(8)]
+	}
+
+	source, _ := filepath.Abs("testdata/frames")
+	os.MkdirAll(source, DEFAULT_PERMISSION)
+	ioutil.WriteFile(filepath.Join(source, "large.dat"), origData, 0644)
+	ioutil.WriteFile(filepath.Join(source, "small.txt"), []byte("Small content, small content, small content"), 0644)
+	defer os.RemoveAll(source)
+
+	target, _ := filepath.Abs("testdata/packed/frames.pack")
+	key := HashingKey(NewCipherSettings("PackSecretKey"))
+
+	for _, compression := range []byte{COMPRESS_GZIP, COMPRESS_ZSTD, COMPRESS_NONE} {
+
+		Convey("Should store the large entry in frames with "+CompressionName(compression), t, func() {
+
+			result := Pack(PackSettings{
+				SourceDir:      source,
+				TargetFilename: target,
+				Compression:    compression,
+				Encryption:     ENCRYPT_AES,
+				Cipher:         NewCipherSettings("PackSecretKey"),
+			})
+			So(result.Err, ShouldBeNil)
+			defer os.Remove(target)
+
+			bundle, err := OpenBundle(target, key)
+			So(err, ShouldBeNil)
+			defer bundle.Close()
+
+			item, err := bundle.GetItemByPath("large.dat")
+			So(err, ShouldBeNil)
+			So(item.Frames, ShouldHaveLength, 4)
+			So(item.Compress, ShouldEqual, compression)
+
+			content, err := bundle.ReadFile(*item)
+			So(err, ShouldBeNil)
+			So(content, ShouldResemble, origData)
+
+			reader, err := bundle.OpenReaderAt("large.dat")
+			So(err, ShouldBeNil)
+
+			// Should read ranges with ReadAt
+			{
+				var tests = []struct {
+					offset int64
+					size   int
+				}{
+					{0, 100},
+					{FRAME_SIZE - 10, 20},
+					{FRAME_SIZE/2 + 1, 2*FRAME_SIZE + 100},
+					{int64(len(origData)) - 1000, 1000},
+				}
+
+				for _, test := range tests {
+					buf := make([]byte, test.size)
+					n, err := reader.ReadAt(buf, test.offset)
+					So(err, ShouldBeNil)
+					So(n, ShouldEqual, test.size)
+					So(buf, ShouldResemble, origData[test.offset:test.offset+int64(test.size)])
+				}
+			}
+
+			// Should give EOF at the end of the content
+			{
+				buf := make([]byte, 100)
+				n, err := reader.ReadAt(buf, int64(len(origData))-40)
+				So(err, ShouldEqual, io.EOF)
+				So(n, ShouldEqual, 40)
+				So(buf[:n], ShouldResemble, origData[len(origData)-40:])
+
+				n, err = reader.ReadAt(buf, int64(len(origData)))
+				So(err, ShouldEqual, io.EOF)
+				So(n, ShouldEqual, 0)
+			}
+
+			// Should read concurrently
+			{
+				var wg sync.WaitGroup
+				errs := make(chan string, 16)
+				for i := 0; i < 16; i++ {
+					wg.Add(1)
+					go func(i int) {
+						defer wg.Done()
+						offset := int64(i) * int64(len(origData)/16)
+						buf := make([]byte, 5000)
+						if _, err := reader.ReadAt(buf, offset); err != nil {
+							errs <- err.Error()
+						} else if string(buf) != string(origData[offset:offset+5000]) {
+							errs <- "different content"
+						}
+					}(i)
+				}
+				wg.Wait()
+				close(errs)
+				So(len(errs), ShouldEqual, 0)
+			}
+
+			// Should give error if the frames don't cover the content
+			{
+				for _, origSize := range []int64{item.OrigSize + 100, item.OrigSize + FRAME_SIZE, item.OrigSize - FRAME_SIZE} {
+					corrupted := *item
+					corrupted.OrigSize = origSize
+
+					_, err := bundle.ReadFile(corrupted)
+					So(err, ShouldNotBeNil)
+
+					buf := make([]byte, 100)
+					_, err = bundle.ItemReaderAt(corrupted).ReadAt(buf, origSize-100)
+					So(err, ShouldNotBeNil)
+				}
+			}
+
+			// Should read the small entry
+			{
+				reader, err := bundle.OpenReaderAt("small.txt")
+				So(err, ShouldBeNil)
+
+				buf := make([]byte, 13)
+				n, err := reader.ReadAt(buf, 6)
+				So(err, ShouldBeNil)
+				So(string(buf[:n]), ShouldEqual, "content, smal")
+			}
+		})
+	}
+
+	Convey("Should decode only the frames of the requested range", t, func() {
+
+		result := Pack(PackSettings{
+			SourceDir:      source,
+			TargetFilename: target,
+			Compression:    COMPRESS_GZIP,
+		})
+		So(result.Err, ShouldBeNil)
+		defer os.Remove(target)
+
+		bundle, err := OpenBundle(target, nil)
+		So(err, ShouldBeNil)
+		item, _ := bundle.GetItemByPath("large.dat")
+
+		// Corrupt the first frame
+		bundle.File.WriteAt(make([]byte, 100), bundle.DataBaseOffset+item.Offset)
+		bundle.Close()
+
+		bundle, err = OpenBundle(target, nil)
+		So(err, ShouldBeNil)
+		defer bundle.Close()
+
+		reader, err := bundle.OpenReaderAt("large.dat")
+		So(err, ShouldBeNil)
+
+		buf := make([]byte, 100)
+		_, err = reader.ReadAt(buf, 2*FRAME_SIZE)
+		So(err, ShouldBeNil)
+		So(buf, ShouldResemble, origData[2*FRAME_SIZE:2*FRAME_SIZE+100])
+	})
+
+	Convey("Should read directly the not transformed entries", t, func() {
+
+		result := Pack(PackSettings{
+			SourceDir:      source,
+			TargetFilename: target,
+		})
+		So(result.Err, ShouldBeNil)
+		defer os.Remove(target)
+
+		bundle, err := OpenBundle(target, nil)
+		So(err, ShouldBeNil)
+		defer bundle.Close()
+
+		item, _ := bundle.GetItemByPath("large.dat")
+		So(item.Frames, ShouldBeNil)
+
+		reader, err := bundle.OpenReaderAt("large.dat")
+		So(err, ShouldBeNil)
+
+		buf := make([]byte, FRAME_SIZE+200)
+		n, err := reader.ReadAt(buf, FRAME_SIZE-100)
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, len(buf))
+		So(buf, ShouldResemble, origData[FRAME_SIZE-100:2*FRAME_SIZE+100])
+
+		{
+			// Should read the content in small pieces
+			content, err := ioutil.ReadAll(io.NewSectionReader(reader, 0, item.OrigSize+100))
+			So(err, ShouldBeNil)
+			So(content, ShouldResemble, origData)
+
+			n, err := reader.ReadAt(buf, item.OrigSize-10)
+			So(err, ShouldEqual, io.EOF)
+			So(n, ShouldEqual, 10)
+			So(buf[:n], ShouldResemble, origData[item.OrigSize-10:])
+		}
+	})
+
+	Convey("Should frame the large entry by the long-range window", t, func() {
+
+		// The same random block twice (so the repeat is more than 1 MB far), and a random tail
+		block := make([]byte, 3*FRAME_SIZE)
+		random.Read(block)
+		data := append(append([]byte{}, block[:2*FRAME_SIZE]...), block...)
+
+		source, _ := filepath.Abs("testdata/window")
+		os.MkdirAll(source, DEFAULT_PERMISSION)
+		ioutil.WriteFile(filepath.Join(source, "repeated.dat"), data, 0644)
+		defer os.RemoveAll(source)
+
+		pack := func(windowSize int) FATItem {
+			result := Pack(PackSettings{
+				SourceDir:      source,
+				TargetFilename: target,
+				Compression:    COMPRESS_ZSTD,
+				WindowSize:     windowSize,
+			})
+			So(result.Err, ShouldBeNil)
+			defer os.Remove(target)
+
+			bundle, err := OpenBundle(target, nil)
+			So(err, ShouldBeNil)
+			defer bundle.Close()
+
+			item, err := bundle.GetItemByPath("repeated.dat")
+			So(err, ShouldBeNil)
+			content, err := bundle.ReadFile(*item)
+			So(err, ShouldBeNil)
+			So(content, ShouldResemble, data)
+
+			buf := make([]byte, 100)
+			_, err = bundle.ItemReaderAt(*item).ReadAt(buf, 3*FRAME_SIZE)
+			So(err, ShouldBeNil)
+			So(buf, ShouldResemble, data[3*FRAME_SIZE:3*FRAME_SIZE+100])
+			return *item
+		}
+
+		// Should not find the repeat in the 1 MB frames
+		item := pack(0)
+		So(item.FrameSize, ShouldEqual, 0)
+		So(item.Size, ShouldBeGreaterThan, len(data)*9/10)
+
+		// Should find the repeat in the window sized frame
+		item = pack(4 * FRAME_SIZE)
+		So(item.FrameSize, ShouldEqual, 4*FRAME_SIZE)
+		So(item.Frames, ShouldHaveLength, 2)
+		So(item.Size, ShouldBeLessThan, len(data)*7/10)
+	})
+}
+
+// countingReader generates a compressible content and counts the read bytes
+type countingReader struct {
+	size int64
+	read int64
+}
+
+func (this *countingReader) Read(p []byte) (int, error) {
+	if this.read >= this.size {
+		return 0, io.EOF
+	}
+	if remaining := this.size - this.read; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	for i := range p {
+		p[i] = byte((this.read + int64(i)) / 1000)
+	}
+	atomic.AddInt64(&this.read, int64(len(p)))
+	return len(p), nil
+}
+
+// aheadWriter records the max count of bytes which are read but not written.
+// Every write is a frame of the content.
+type aheadWriter struct {
+	io.WriterAt
+	reader  *countingReader
+	written int64
+	ahead   int64
+}
+
+func (this *aheadWriter) WriteAt(p []byte, off int64) (int, error) {
+	if ahead := atomic.LoadInt64(&this.reader.read) - this.written; ahead > this.ahead {
+		this.ahead = ahead
+	}
+	this.written += FRAME_SIZE
+	return this.WriterAt.WriteAt(p, off)
+}
+
+func TestStreamedEntries(t *testing.T) {
+
+	Convey("Should stream the large content in frames", t, func() {
+
+		filename := "testdata/packed/stream.pack"
+		defer os.Remove(filename)
+		bundle, err := CreateBundle(filename, BundleSettings{Compression: COMPRESS_GZIP, Level: 1})
+		So(err, ShouldBeNil)
+
+		reader := &countingReader{size: 200 * FRAME_SIZE}
+		writer := &aheadWriter{WriterAt: bundle.writer, reader: reader}
+		bundle.writer = writer
+
+		item := &FATItem{Path: "stream.dat"}
+		So(bundle.writeFrames(item, reader, COMPRESS_GZIP), ShouldBeNil)
+		_, err = bundle.appendItem(item)
+		So(err, ShouldBeNil)
+		bundle.writer = writer.WriterAt
+		So(bundle.Finalize(), ShouldBeNil)
+		So(bundle.Close(), ShouldBeNil)
+
+		// Should keep only a few frames in memory
+		So(item.Frames, ShouldHaveLength, 200)
+		So(writer.ahead, ShouldBeLessThanOrEqualTo, (FRAME_BUFFER_SIZE/FRAME_SIZE+2)*FRAME_SIZE)
+
+		bundle, err = OpenBundle(filename, nil)
+		So(err, ShouldBeNil)
+		defer bundle.Close()
+
+		content, err := bundle.ReadFileFromPath("stream.dat")
+		So(err, ShouldBeNil)
+		expected, _ := ioutil.ReadAll(&countingReader{size: 200 * FRAME_SIZE})
+		So(content, ShouldResemble, expected)
+		So(item.Hash, ShouldEqual, sha512.Sum512(expected))
+	})
+
+	Convey("Should skip the duplicated large files and store the incompressible ones", t, func() {
+
+		random := rand.New(rand.NewSource(1))
+		compressible, _ := ioutil.ReadAll(&countingReader{size: 3 * FRAME_SIZE})
+		incompressible := make([]byte, 3*FRAME_SIZE)
+		random.Read(incompressible)
+
+		source, _ := filepath.Abs("testdata/streamed")
+		os.MkdirAll(source, DEFAULT_PERMISSION)
+		ioutil.WriteFile(filepath.Join(source, "large.dat"), compressible, 0644)
+		ioutil.WriteFile(filepath.Join(source, "large-copy.dat"), compressible, 0644)
+		ioutil.WriteFile(filepath.Join(source, "random.dat"), incompressible, 0644)
+		defer os.RemoveAll(source)
+
+		target, _ := filepath.Abs("testdata/packed/streamed.pack")
+
+		for _, workers := range []int{1, 4} {
+			result := Pack(PackSettings{
+				SourceDir:      source,
+				TargetFilename: target,
+				Compression:    COMPRESS_GZIP,
+				Workers:        workers,
+			})
+			So(result.Err, ShouldBeNil)
+			So(result.DupCount, ShouldEqual, 1)
+
+			bundle, err := OpenBundle(target, nil)
+			So(err, ShouldBeNil)
+
+			large, _ := bundle.GetItemByPath("large.dat")
+			largeCopy, _ := bundle.GetItemByPath("large-copy.dat")
+			So(largeCopy.Offset, ShouldEqual, large.Offset)
+			So(large.Compress, ShouldEqual, COMPRESS_GZIP)
+			So(large.Frames, ShouldHaveLength, 3)
+
+			item, _ := bundle.GetItemByPath("random.dat")
+			So(item.Compress, ShouldEqual, COMPRESS_NONE)
+			So(item.Frames, ShouldBeNil)
+			So(item.Size, ShouldEqual, len(incompressible))
+
+			content, err := bundle.ReadFile(*largeCopy)
+			So(err, ShouldBeNil)
+			So(content, ShouldResemble, compressible)
+			content, err = bundle.ReadFile(*item)
+			So(err, ShouldBeNil)
+			So(content, ShouldResemble, incompressible)
+
+			bundle.Close()
+			os.Remove(target)
+		}
+	})
+}
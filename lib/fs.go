@@ -0,0 +1,194 @@
+//go:build go1.16
+// +build go1.16
+
+package icepacker
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"time"
+)
+
+// BundleFS is a read-only fs.FS of the opened bundle. It implements the
+// fs.ReadDirFS, fs.StatFS & fs.ReadFileFS interfaces, so it can be used
+// with template.ParseFS, http.FS or fs.WalkDir.
+type BundleFS struct {
+	bundle *BundleFile
+}
+
+// FS returns the fs.FS of the bundle. It is valid until the bundle is closed.
+func (this *BundleFile) FS() *BundleFS {
+	return &BundleFS{bundle: this}
+}
+
+// stat returns the entry of the valid path
+func (this *BundleFS) stat(op string, name string) (*DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	entry, err := this.bundle.Stat(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return entry, nil
+}
+
+// Open opens the file or the directory
+func (this *BundleFS) Open(name string) (fs.File, error) {
+	entry, err := this.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.IsDir {
+		return &bundleDir{fs: this, info: fileInfo{*entry}, path: name}, nil
+	}
+
+	reader := this.bundle.itemReader(*entry.Item)
+	return &bundleFSFile{info: fileInfo{*entry}, SectionReader: io.NewSectionReader(reader, 0, entry.Item.OrigSize)}, nil
+}
+
+// Stat returns the FileInfo of the file or the directory
+func (this *BundleFS) Stat(name string) (fs.FileInfo, error) {
+	entry, err := this.stat("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo{*entry}, nil
+}
+
+// ReadDir returns the entries of the directory sorted by name
+func (this *BundleFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entry, err := this.stat("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !entry.IsDir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+
+	entries, err := this.bundle.ReadDir(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+
+	res := make([]fs.DirEntry, len(entries))
+	for i, entry := range entries {
+		res[i] = fs.FileInfoToDirEntry(fileInfo{entry})
+	}
+	return res, nil
+}
+
+// ReadFile reads the whole content of the file. The caller can modify the returned content.
+func (this *BundleFS) ReadFile(name string) ([]byte, error) {
+	entry, err := this.stat("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+	if entry.IsDir {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: errors.New("is a directory")}
+	}
+
+	content, err := this.bundle.ReadFile(*entry.Item)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+
+	// The content can be a slice of the mapped bundle
+	res := make([]byte, len(content))
+	copy(res, content)
+	return res, nil
+}
+
+// fileInfo is the fs.FileInfo of an entry backed by the FAT item
+type fileInfo struct {
+	entry DirEntry
+}
+
+func (this fileInfo) Name() string { return this.entry.Name }
+func (this fileInfo) IsDir() bool  { return this.entry.IsDir }
+
+func (this fileInfo) Size() int64 {
+	if this.entry.IsDir {
+		return 0
+	}
+	return this.entry.Item.OrigSize
+}
+
+func (this fileInfo) Mode() fs.FileMode {
+	if this.entry.IsDir {
+		return fs.ModeDir | 0555
+	}
+	return fs.FileMode(this.entry.Item.Mode)
+}
+
+func (this fileInfo) ModTime() time.Time {
+	if this.entry.IsDir {
+		return time.Time{}
+	}
+	return time.Unix(0, this.entry.Item.MTime)
+}
+
+// Sys returns the *FATItem of files (nil on directories)
+func (this fileInfo) Sys() interface{} {
+	return this.entry.Item
+}
+
+// bundleFSFile is an opened file of the BundleFS. It supports Seek & ReadAt.
+type bundleFSFile struct {
+	*io.SectionReader
+	info fileInfo
+}
+
+func (this *bundleFSFile) Stat() (fs.FileInfo, error) { return this.info, nil }
+func (this *bundleFSFile) Close() error               { return nil }
+
+// bundleDir is an opened directory of the BundleFS
+type bundleDir struct {
+	fs      *BundleFS
+	info    fileInfo
+	path    string
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (this *bundleDir) Stat() (fs.FileInfo, error) { return this.info, nil }
+func (this *bundleDir) Close() error               { return nil }
+
+func (this *bundleDir) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: this.path, Err: errors.New("is a directory")}
+}
+
+// ReadDir returns the next `n` entries of the directory (or all, if n <= 0)
+func (this *bundleDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if this.entries == nil {
+		entries, err := this.fs.ReadDir(this.path)
+		if err != nil {
+			return nil, err
+		}
+		this.entries = entries
+	}
+
+	rest := this.entries[this.offset:]
+	if n > 0 {
+		if len(rest) == 0 {
+			return nil, io.EOF
+		}
+		if n < len(rest) {
+			rest = rest[:n]
+		}
+	}
+	this.offset += len(rest)
+	return rest, nil
+}
+
+// OpenSelfFS opens the bundle appended to the running executable (see
+// OpenSelf) as an fs.FS. The bundle is kept open for the lifetime of the process.
+func OpenSelfFS(cipherKey []byte) (fs.FS, error) {
+	bundle, err := OpenSelf(cipherKey)
+	if err != nil {
+		return nil, err
+	}
+	return bundle.FS(), nil
+}
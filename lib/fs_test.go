@@ -0,0 +1,105 @@
+//go:build go1.16
+// +build go1.16
+
+package icepacker
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBundleFS(t *testing.T) {
+
+	source, _ := filepath.Abs("testdata/simple")
+	target, _ := filepath.Abs("testdata/packed/fs.pack")
+	defer os.Remove(target)
+
+	key := HashingKey(NewCipherSettings("PackSecretKey"))
+	result := Pack(PackSettings{
+		SourceDir:      source,
+		TargetFilename: target,
+		Compression:    COMPRESS_GZIP,
+		Encryption:     ENCRYPT_AES,
+		Cipher:         NewCipherSettings("PackSecretKey"),
+	})
+
+	Convey("Should implement the fs.FS interfaces", t, func() {
+		So(result.Err, ShouldBeNil)
+
+		bundle, err := OpenBundle(target, key)
+		So(err, ShouldBeNil)
+		defer bundle.Close()
+
+		fsys := bundle.FS()
+		So(fstest.TestFS(fsys, "file1.txt", "dir1/file3.txt", "dir1/icon1.png", "dir2/index.html", ".hidden"), ShouldBeNil)
+
+		{
+			// Should back the FileInfo by the FAT item
+			info, err := fs.Stat(fsys, "dir1/file3.txt")
+			So(err, ShouldBeNil)
+			So(info.Size(), ShouldEqual, 2382)
+			So(info.Mode().IsRegular(), ShouldBeTrue)
+
+			orig, _ := os.Stat(filepath.Join(source, "dir1/file3.txt"))
+			So(info.ModTime().Equal(orig.ModTime()), ShouldBeTrue)
+			So(info.Sys().(*FATItem).Path, ShouldEqual, "dir1/file3.txt")
+
+			info, err = fs.Stat(fsys, "dir2")
+			So(err, ShouldBeNil)
+			So(info.IsDir(), ShouldBeTrue)
+		}
+
+		{
+			// Should read the files
+			content, err := fs.ReadFile(fsys, "dir1/file3.txt")
+			So(err, ShouldBeNil)
+			orig, _ := ioutil.ReadFile(filepath.Join(source, "dir1/file3.txt"))
+			So(content, ShouldResemble, orig)
+
+			matches, err := fs.Glob(fsys, "dir*/*.png")
+			So(err, ShouldBeNil)
+			So(matches, ShouldResemble, []string{"dir1/icon1.png", "dir2/icon-same.png"})
+		}
+
+		{
+			// Should give the errors of fs
+			_, err := fsys.Open("dir3/file.txt")
+			So(os.IsNotExist(err), ShouldBeTrue)
+			_, err = fsys.Open("/file1.txt")
+			So(err.(*fs.PathError).Err, ShouldEqual, fs.ErrInvalid)
+			_, err = fsys.ReadDir("file1.txt")
+			So(err, ShouldNotBeNil)
+		}
+	})
+
+	Convey("Should pass the tests of fs.FS on a lazily opened bundle", t, func() {
+		bundle, err := OpenBundleLazy(target, key)
+		So(err, ShouldBeNil)
+		defer bundle.Close()
+
+		So(fstest.TestFS(bundle.FS(), "file1.txt", "dir1/file3.txt", "dir2/icon-same.png"), ShouldBeNil)
+	})
+
+	Convey("Should open the bundle appended to the executable as fs.FS", t, func() {
+		_, err := OpenSelfFS(key)
+		So(err, ShouldEqual, ErrNoBundle)
+
+		executable = func() (string, error) { return target, nil }
+		defer func() { executable = os.Executable }()
+
+		fsys, err := OpenSelfFS(key)
+		So(err, ShouldBeNil)
+		defer fsys.(*BundleFS).bundle.Close()
+
+		content, err := fs.ReadFile(fsys, "dir2/index.html")
+		So(err, ShouldBeNil)
+		expected, _ := ioutil.ReadFile(filepath.Join(source, "dir2", "index.html"))
+		So(content, ShouldResemble, expected)
+	})
+}
@@ -29,7 +29,7 @@ func NewHeader(encryption, compression byte) *Header {
 	header := new(Header)
 
 	header.Magic = []byte(MagicBytes)
-	header.Version = VERSION_1
+	header.Version = VERSION
 	header.Encrypt = encryption
 	header.Compress = compression
 	header.FatSize = 0
@@ -68,7 +68,7 @@ func GetHeader(pack io.Reader) (*Header, error) {
 		return nil, errors.New("Invalid file format!")
 	}
 
-	if header.Version != VERSION_1 {
+	if header.Version < VERSION_1 || header.Version > VERSION {
 		return nil, fmt.Errorf("Invalid file version (%d)!", header.Version)
 	}
 
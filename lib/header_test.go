@@ -16,7 +16,7 @@ func TestNewHeaderConstructor(t *testing.T) {
 		header := NewHeader(ENCRYPT_AES, COMPRESS_GZIP)
 
 		So(header.Magic, ShouldResemble, []byte(MagicBytes))
-		So(header.Version, ShouldEqual, VERSION_1)
+		So(header.Version, ShouldEqual, VERSION)
 		So(header.Encrypt, ShouldEqual, ENCRYPT_AES)
 		So(header.Compress, ShouldEqual, COMPRESS_GZIP)
 		So(header.FatSize, ShouldEqual, 0)
@@ -36,7 +36,7 @@ func TestHeaderWrite(t *testing.T) {
 		w := new(bytes.Buffer)
 		err := header.Write(w)
 		So(err, ShouldBeNil)
-		So(w.Bytes(), ShouldResemble, []uint8{73, 80, 65, 67, 75, 1, 1, 1, 57, 48, 0, 0, 0, 0, 0, 0, 21, 205, 91, 7, 0, 0, 0, 0})
+		So(w.Bytes(), ShouldResemble, []uint8{73, 80, 65, 67, 75, 2, 1, 1, 57, 48, 0, 0, 0, 0, 0, 0, 21, 205, 91, 7, 0, 0, 0, 0})
 	})
 
 }
@@ -57,6 +57,16 @@ func TestGetHeader(t *testing.T) {
 		So(header.Created, ShouldEqual, 123456789)
 	})
 
+	Convey("Should load Header struct of the current version", t, func() {
+		r := bytes.NewReader([]uint8{73, 80, 65, 67, 75, 2, 0, 1, 57, 48, 0, 0, 0, 0, 0, 0, 21, 205, 91, 7, 0, 0, 0, 0})
+
+		header, err := GetHeader(r)
+		So(err, ShouldBeNil)
+		So(header.Version, ShouldEqual, VERSION_2)
+		So(header.Encrypt, ShouldEqual, ENCRYPT_NONE)
+		So(header.Compress, ShouldEqual, COMPRESS_GZIP)
+	})
+
 	Convey("Should give error if size if small than HEADER_SIZE", t, func() {
 		r := bytes.NewReader([]uint8{0, 0, 0, 0})
 
@@ -74,10 +84,10 @@ func TestGetHeader(t *testing.T) {
 	})
 
 	Convey("Should give error if size Magic is not equal", t, func() {
-		r := bytes.NewReader([]uint8{73, 80, 65, 67, 75, 2, 1, 1, 57, 48, 0, 0, 0, 0, 0, 0, 21, 205, 91, 7, 0, 0, 0, 0})
+		r := bytes.NewReader([]uint8{73, 80, 65, 67, 75, 3, 1, 1, 57, 48, 0, 0, 0, 0, 0, 0, 21, 205, 91, 7, 0, 0, 0, 0})
 
 		header, err := GetHeader(r)
-		So(err, ShouldResemble, errors.New("Invalid file version (2)!"))
+		So(err, ShouldResemble, errors.New("Invalid file version (3)!"))
 		So(header, ShouldBeNil)
 	})
 }
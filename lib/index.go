@@ -0,0 +1,293 @@
+package icepacker
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+)
+
+// FAT_PAGE_SIZE is the count of the items in a page of the FAT index
+const FAT_PAGE_SIZE = 1024
+
+// FATIndex is the directory of the paged FAT. The items are sorted by path
+// and stored in pages which are transformed (compressed & encrypted)
+// independently, so an item can be found by binary search, loading only
+// one page.
+//
+// The layout of the FAT block: | pages | directory | size of directory (int64) |
+type FATIndex struct {
+	Count int64     `json:"count"`
+	Size  int64     `json:"size"`
+	Pages []FATPage `json:"pages"`
+
+	// The shared compression dictionary (at DictOffset with DictSize)
+	DictOffset int64 `json:"dictOffset,omitempty"`
+	DictSize   int64 `json:"dictSize,omitempty"`
+}
+
+// FATPage records a page of the FAT index
+type FATPage struct {
+	// The path of the first item of the page
+	First string `json:"first"`
+
+	// The offset (from the begin of the FAT block) & the size of the transformed page
+	Offset int64 `json:"offset"`
+	Size   int64 `json:"size"`
+	Count  int   `json:"count"`
+}
+
+// pageItem is an item in a page of the FAT index with its index in the
+// FAT.Items and the hash of the content. The pages are sorted by path, but
+// the FAT.Items are kept in the order of packing.
+type pageItem struct {
+	FATItem
+	Index int    `json:"index"`
+	Hash  []byte `json:"hash,omitempty"`
+}
+
+// newPageItem creates the pageItem of the i-th item of the FAT
+func newPageItem(item FATItem, i int) pageItem {
+	res := pageItem{FATItem: item, Index: i}
+	if item.Hash != [64]byte{} {
+		res.Hash = item.Hash[:]
+	}
+	return res
+}
+
+// item returns the FATItem with the hash
+func (this pageItem) item() FATItem {
+	item := this.FATItem
+	copy(item.Hash[:], this.Hash)
+	return item
+}
+
+// pageCache caches the last loaded page of the FAT index
+type pageCache struct {
+	lock  sync.Mutex
+	page  int
+	items []pageItem
+}
+
+// encodeIndex encodes the FAT to a paged, path-sorted FAT block
+func encodeIndex(fat FAT, compression byte, encryption byte, cipherKey []byte) ([]byte, error) {
+	items := make([]pageItem, len(fat.Items))
+	for i, item := range fat.Items {
+		items[i] = newPageItem(item, i)
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Path < items[j].Path })
+
+	index := FATIndex{Count: fat.Count, Size: fat.Size, DictOffset: fat.DictOffset, DictSize: fat.DictSize}
+	block := []byte{}
+
+	for begin := 0; begin < len(items); begin += FAT_PAGE_SIZE {
+		end := begin + FAT_PAGE_SIZE
+		if end > len(items) {
+			end = len(items)
+		}
+
+		page, err := json.Marshal(items[begin:end])
+		if err != nil {
+			return nil, err
+		}
+		blob, err := TransformPack(page, compression, encryption, cipherKey)
+		if err != nil {
+			return nil, err
+		}
+
+		index.Pages = append(index.Pages, FATPage{First: items[begin].Path, Offset: int64(len(block)), Size: int64(len(blob)), Count: end - begin})
+		block = append(block, blob...)
+	}
+
+	directory, err := json.Marshal(index)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := TransformPack(directory, compression, encryption, cipherKey)
+	if err != nil {
+		return nil, err
+	}
+	block = append(block, blob...)
+
+	size := make([]byte, 8)
+	ByteOrder.PutUint64(size, uint64(len(blob)))
+	return append(block, size...), nil
+}
+
+// readIndex reads the directory of the FAT index from the FAT block at `fatOffset`
+func readIndex(reader io.ReaderAt, fatOffset int64, header *Header, cipherKey []byte) (*FATIndex, error) {
+	if header.FatSize < 8 {
+		return nil, errors.New("Invalid FAT index!")
+	}
+
+	buf := make([]byte, 8)
+	if _, err := reader.ReadAt(buf, fatOffset+header.FatSize-8); err != nil {
+		return nil, err
+	}
+	size := int64(ByteOrder.Uint64(buf))
+	if size < 0 || size > header.FatSize-8 {
+		return nil, errors.New("Invalid FAT index!")
+	}
+
+	blob := make([]byte, size)
+	if _, err := reader.ReadAt(blob, fatOffset+header.FatSize-8-size); err != nil {
+		return nil, err
+	}
+	directory, err := TransformUnpack(blob, header.Compress, header.Encrypt, cipherKey)
+	if err != nil {
+		return nil, err
+	}
+
+	index := new(FATIndex)
+	if err := json.Unmarshal(directory, index); err != nil {
+		return nil, err
+	}
+
+	for _, page := range index.Pages {
+		if page.Offset < 0 || page.Size < 0 || page.Offset+page.Size > header.FatSize-8-size {
+			return nil, errors.New("Invalid FAT index!")
+		}
+	}
+	return index, nil
+}
+
+// loadPage reads & decodes the page of the FAT index
+func (this *BundleFile) loadPage(i int) ([]pageItem, error) {
+	page := this.index.Pages[i]
+	blob, err := this.readRaw(this.fatOffset+page.Offset, page.Size)
+	if err != nil {
+		return nil, err
+	}
+	content, err := TransformUnpack(blob, this.Header.Compress, this.Header.Encrypt, this.Settings.CipherKey)
+	if err != nil {
+		return nil, err
+	}
+
+	items := []pageItem{}
+	if err := json.Unmarshal(content, &items); err != nil {
+		return nil, err
+	}
+	if len(items) != page.Count {
+		return nil, errors.New("Invalid FAT page!")
+	}
+	for _, item := range items {
+		if item.Hash != nil && len(item.Hash) != len(item.FATItem.Hash) {
+			return nil, errors.New("Invalid FAT page!")
+		}
+	}
+
+	// Check the codecs of files
+	for _, item := range items {
+		if _, err := GetCompressor(item.Compress); err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
+
+// loadItems loads every page of the FAT index, and returns the items in
+// the order of packing
+func (this *BundleFile) loadItems() ([]FATItem, error) {
+	count := 0
+	for _, page := range this.index.Pages {
+		count += page.Count
+	}
+
+	res := make([]FATItem, count)
+	loaded := make([]bool, count)
+	for i := range this.index.Pages {
+		items, err := this.loadPage(i)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			if item.Index < 0 || item.Index >= count || loaded[item.Index] {
+				return nil, errors.New("Invalid FAT page!")
+			}
+			res[item.Index] = item.item()
+			loaded[item.Index] = true
+		}
+	}
+	return res, nil
+}
+
+// readPage returns the page of the FAT index. The last read page is cached.
+func (this *BundleFile) readPage(i int) ([]pageItem, error) {
+	this.pageCache.lock.Lock()
+	defer this.pageCache.lock.Unlock()
+
+	if this.pageCache.items != nil && this.pageCache.page == i {
+		return this.pageCache.items, nil
+	}
+
+	items, err := this.loadPage(i)
+	if err != nil {
+		return nil, err
+	}
+	this.pageCache.page = i
+	this.pageCache.items = items
+	return items, nil
+}
+
+// lookupItem finds the item by path with binary search in the FAT index
+func (this *BundleFile) lookupItem(path string) (*FATItem, error) {
+	pages := this.index.Pages
+
+	// The first page which begins with the path or after it. The item is
+	// in the previous page, or it is the first item of this page.
+	next := sort.Search(len(pages), func(i int) bool { return pages[i].First >= path })
+
+	for i := next - 1; i <= next && i < len(pages); i++ {
+		if i < 0 {
+			continue
+		}
+
+		items, err := this.readPage(i)
+		if err != nil {
+			return nil, err
+		}
+
+		j := sort.Search(len(items), func(j int) bool { return items[j].Path >= path })
+		if j < len(items) && items[j].Path == path {
+			item := items[j].item()
+			return &item, nil
+		}
+	}
+	return nil, errors.New("File not found! Path: " + path)
+}
+
+// IsLazy returns true if the bundle is opened with OpenBundleLazy, so the
+// items of FAT are not loaded
+func (this *BundleFile) IsLazy() bool {
+	return this.lazy
+}
+
+// Walk calls `fn` for every item of the FAT. On the lazily opened bundles
+// the pages of the FAT index are loaded one by one, so the whole FAT is
+// not loaded into the memory, and the items are walked in the order of path
+// (otherwise in the order of packing).
+// If `fn` returns an error, the walking stops and the error is returned.
+func (this *BundleFile) Walk(fn func(item FATItem) error) error {
+	if !this.lazy {
+		for _, item := range this.FAT.Items {
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for i := range this.index.Pages {
+		items, err := this.loadPage(i)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			if err := fn(item.item()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
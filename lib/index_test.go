@@ -0,0 +1,203 @@
+package icepacker
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFATIndex(t *testing.T) {
+
+	source, _ := filepath.Abs("testdata/many")
+	target, _ := filepath.Abs("testdata/packed/many.pack")
+	defer os.RemoveAll(source)
+	defer os.Remove(target)
+
+	// More files than the size of 2 pages
+	count := 2*FAT_PAGE_SIZE + 100
+	paths := []string{}
+	for i := 0; i < count; i++ {
+		path := fmt.Sprintf("dir%d/file-%04d.txt", i%3, i)
+		os.MkdirAll(filepath.Join(source, filepath.Dir(path)), DEFAULT_PERMISSION)
+		ioutil.WriteFile(filepath.Join(source, path), []byte("Content of "+path), 0644)
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	key := HashingKey(NewCipherSettings("PackSecretKey"))
+	result := Pack(PackSettings{
+		SourceDir:      source,
+		TargetFilename: target,
+		Compression:    COMPRESS_GZIP,
+		Encryption:     ENCRYPT_AES,
+		Cipher:         NewCipherSettings("PackSecretKey"),
+	})
+
+	Convey("Should store the FAT in pages", t, func() {
+		So(result.Err, ShouldBeNil)
+
+		bundle, err := OpenBundleLazy(target, key)
+		So(err, ShouldBeNil)
+		defer bundle.Close()
+
+		So(bundle.IsLazy(), ShouldBeTrue)
+		So(bundle.FAT.Items, ShouldBeNil)
+		So(bundle.FAT.Count, ShouldEqual, count)
+		So(len(bundle.index.Pages), ShouldEqual, 3)
+		So(bundle.index.Pages[1].First, ShouldEqual, paths[FAT_PAGE_SIZE])
+
+		{
+			// Should find the files by binary search
+			for _, i := range []int{0, 1, FAT_PAGE_SIZE - 1, FAT_PAGE_SIZE, count/2 + 7, count - 1} {
+				content, err := bundle.ReadFileFromPath(paths[i])
+				So(err, ShouldBeNil)
+				So(string(content), ShouldEqual, "Content of "+paths[i])
+
+				item, err := bundle.GetItemByPath(paths[i])
+				So(err, ShouldBeNil)
+				So(item.Hash, ShouldEqual, sha512.Sum512(content))
+			}
+
+			_, err = bundle.GetItemByPath("dir1/not-exists.txt")
+			So(err.Error(), ShouldEqual, "File not found! Path: dir1/not-exists.txt")
+			_, err = bundle.GetItemByPath("")
+			So(err, ShouldNotBeNil)
+			_, err = bundle.GetItemByPath("zzz")
+			So(err, ShouldNotBeNil)
+		}
+
+		{
+			// Should walk the items sorted by path
+			walked := []string{}
+			err := bundle.Walk(func(item FATItem) error {
+				walked = append(walked, item.Path)
+				return nil
+			})
+			So(err, ShouldBeNil)
+			So(walked, ShouldResemble, paths)
+
+			// Should stop the walking on error
+			n := 0
+			err = bundle.Walk(func(item FATItem) error {
+				n++
+				return fmt.Errorf("Stop")
+			})
+			So(err.Error(), ShouldEqual, "Stop")
+			So(n, ShouldEqual, 1)
+		}
+
+		{
+			// Should not modify the lazily opened bundle
+			_, err := bundle.AddFile("new.txt", filepath.Join(source, paths[0]))
+			So(err.Error(), ShouldEqual, "The lazily opened bundle can't be modified!")
+		}
+	})
+
+	Convey("Should load the whole paged FAT with OpenBundle", t, func() {
+		bundle, err := OpenBundle(target, key)
+		So(err, ShouldBeNil)
+		defer bundle.Close()
+
+		So(bundle.IsLazy(), ShouldBeFalse)
+		So(len(bundle.FAT.Items), ShouldEqual, count)
+		So(bundle.FAT.Items[count-1].Path, ShouldEqual, paths[count-1])
+
+		content, err := bundle.ReadFileFromPath(paths[FAT_PAGE_SIZE])
+		So(err, ShouldBeNil)
+		So(string(content), ShouldEqual, "Content of "+paths[FAT_PAGE_SIZE])
+	})
+
+	Convey("Should find the duplicated paths on the border of pages", t, func() {
+		items := make([]FATItem, FAT_PAGE_SIZE+1)
+		for i := range items {
+			items[i] = FATItem{Path: fmt.Sprintf("file-%04d", i), Offset: int64(i)}
+		}
+		items[FAT_PAGE_SIZE] = FATItem{Path: items[FAT_PAGE_SIZE-1].Path, Offset: -1}
+
+		block, err := encodeIndex(FAT{Count: int64(len(items)), Items: items}, COMPRESS_NONE, ENCRYPT_NONE, nil)
+		So(err, ShouldBeNil)
+
+		header := NewHeader(ENCRYPT_NONE, COMPRESS_NONE)
+		header.FatSize = int64(len(block))
+		index, err := readIndex(bytes.NewReader(block), 0, header, nil)
+		So(err, ShouldBeNil)
+		So(len(index.Pages), ShouldEqual, 2)
+
+		bundle := BundleFile{reader: bytes.NewReader(block), Header: header, index: index, lazy: true}
+		item, err := bundle.GetItemByPath(items[FAT_PAGE_SIZE-1].Path)
+		So(err, ShouldBeNil)
+		So(item.Offset, ShouldEqual, FAT_PAGE_SIZE-1)
+	})
+
+	Convey("Should keep the order of packing in the FAT items", t, func() {
+		ordered, _ := filepath.Abs("testdata/packed/ordered.pack")
+		defer os.Remove(ordered)
+
+		bundle, err := CreateBundle(ordered, BundleSettings{})
+		So(err, ShouldBeNil)
+		for _, path := range []string{"b.txt", "c/a.txt", "a.txt"} {
+			_, err = bundle.addItem(&FATItem{Path: path, OrigSize: int64(len(path))}, []byte(path))
+			So(err, ShouldBeNil)
+		}
+		So(bundle.Finalize(), ShouldBeNil)
+		bundle.Close()
+
+		bundle, err = OpenBundle(ordered, nil)
+		So(err, ShouldBeNil)
+		defer bundle.Close()
+		So(bundle.FAT.Items, ShouldHaveLength, 3)
+		So(bundle.FAT.Items[0].Path, ShouldEqual, "b.txt")
+		So(bundle.FAT.Items[1].Path, ShouldEqual, "c/a.txt")
+		So(bundle.FAT.Items[2].Path, ShouldEqual, "a.txt")
+
+		// The lazily opened bundle is walked in the order of path
+		lazy, err := OpenBundleLazy(ordered, nil)
+		So(err, ShouldBeNil)
+		defer lazy.Close()
+		walked := []string{}
+		lazy.Walk(func(item FATItem) error {
+			walked = append(walked, item.Path)
+			return nil
+		})
+		So(walked, ShouldResemble, []string{"a.txt", "b.txt", "c/a.txt"})
+	})
+
+	Convey("Should open the older bundles fully with OpenBundleLazy", t, func() {
+		old, _ := filepath.Abs("testdata/packed/old.pack")
+		defer os.Remove(old)
+
+		content := []byte("Content of the file in an old bundle.")
+		fatJSON := []byte(`{"count":1,"size":` + fmt.Sprint(len(content)) + `,"items":[{"path":"file.txt","offset":0,"size":` + fmt.Sprint(len(content)) + `,"origSize":` + fmt.Sprint(len(content)) + `,"mTime":0,"mode":420,"perm":420}]}`)
+
+		header := NewHeader(ENCRYPT_NONE, COMPRESS_NONE)
+		header.Version = VERSION_1
+		header.FatSize = int64(len(fatJSON))
+		footer := NewFooter()
+		footer.PackSize = HEADER_SIZE + int64(len(content)+len(fatJSON)) + FOOTER_SIZE
+
+		f, err := os.Create(old)
+		So(err, ShouldBeNil)
+		header.Write(f)
+		f.Write(content)
+		f.Write(fatJSON)
+		footer.Write(f)
+		f.Close()
+
+		bundle, err := OpenBundleLazy(old, nil)
+		So(err, ShouldBeNil)
+		defer bundle.Close()
+
+		So(bundle.IsLazy(), ShouldBeFalse)
+		So(len(bundle.FAT.Items), ShouldEqual, 1)
+		res, err := bundle.ReadFileFromPath("file.txt")
+		So(err, ShouldBeNil)
+		So(res, ShouldResemble, content)
+	})
+}
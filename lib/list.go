@@ -5,24 +5,33 @@ type ListSettings struct {
 	PackFileName string
 	Cipher       CipherSettings
 	OnFinish     chan ListResult
+
+	// Storage stores the bundle (PackFileName is the name in the storage).
+	// If it's nil, the bundle is opened with OpenPack.
+	Storage Storage
 }
 
-// Finish returns a success ListResult instance and put to the OnFinish channel if it's not nil
+// Finish returns a success ListResult instance and sends it to the OnFinish
+// channel if it's not nil
 func (this *ListSettings) Finish(err error, fat *FAT) ListResult {
 	ret := ListResult{err, fat}
-	if this.OnFinish != nil {
-		this.OnFinish <- ret
-	}
+	this.send(ret)
 	return ret
 }
 
-// Finish returns an errored ListResult instance and put to the OnFinish channel if it's not nil
+// FinishError returns an errored ListResult instance and sends it to the OnFinish
+// channel if it's not nil
 func (this *ListSettings) FinishError(err error) ListResult {
 	ret := ListResult{Err: err}
+	this.send(ret)
+	return ret
+}
+
+// send sends the result to the OnFinish channel and waits for the reader
+func (this *ListSettings) send(result ListResult) {
 	if this.OnFinish != nil {
-		this.OnFinish <- ret
+		this.OnFinish <- result
 	}
-	return ret
 }
 
 // ListPack lists the FAT from the package. Returns a ListResult instance with the FAT
@@ -32,7 +41,7 @@ func ListPack(settings ListSettings) ListResult {
 	shaKey := HashingKey(settings.Cipher)
 
 	// Open the bundle file
-	bundle, err := OpenBundle(settings.PackFileName, shaKey)
+	bundle, err := openBundleIn(settings.Storage, settings.PackFileName, shaKey)
 	if err != nil {
 		return settings.FinishError(err)
 	}
@@ -0,0 +1,132 @@
+package icepacker
+
+import (
+	"log"
+)
+
+// NopListener is a Listener which ignores every event
+type NopListener struct{}
+
+// OnScanStart does nothing
+func (NopListener) OnScanStart(source string) {}
+
+// OnFileStart does nothing
+func (NopListener) OnFileStart(state FileState) {}
+
+// OnFileProgress does nothing
+func (NopListener) OnFileProgress(state FileState) {}
+
+// OnFileDone does nothing
+func (NopListener) OnFileDone(state FileState) {}
+
+// OnError does nothing
+func (NopListener) OnError(err error, filename string) {}
+
+// OnFinish does nothing
+func (NopListener) OnFinish(result FinishResult) {}
+
+// ChanListener is a Listener which pushes the events to channels.
+// The states (processed files, errors and the final state with Index == Total)
+// are pushed to the Progress channel only if it has free space (or a waiting
+// reader), so a slow or missing reader never blocks the process; use a
+// buffered channel to keep the errors. The FinishResult is sent to the Finish
+// channel with a blocking send, so the Finish channel must be read.
+type ChanListener struct {
+	Progress chan ProgressState
+	Finish   chan FinishResult
+}
+
+// OnScanStart does nothing
+func (this ChanListener) OnScanStart(source string) {}
+
+// OnFileStart does nothing
+func (this ChanListener) OnFileStart(state FileState) {}
+
+// OnFileProgress does nothing
+func (this ChanListener) OnFileProgress(state FileState) {}
+
+// OnFileDone pushes a success ProgressState to the Progress channel
+func (this ChanListener) OnFileDone(state FileState) {
+	this.push(ProgressState{nil, state.Total, state.Index + 1, state.Path})
+}
+
+// OnError pushes an error ProgressState to the Progress channel
+func (this ChanListener) OnError(err error, filename string) {
+	this.push(ProgressState{err, 0, 0, filename})
+}
+
+// OnFinish pushes the final ProgressState to the Progress channel (on success)
+// and sends the FinishResult to the Finish channel
+func (this ChanListener) OnFinish(result FinishResult) {
+	if result.Err == nil {
+		total := int(result.FileCount)
+		this.push(ProgressState{nil, total, total, ""})
+	}
+
+	if this.Finish != nil {
+		this.Finish <- result
+	}
+}
+
+// push sends the state to the Progress channel if it has free space
+func (this ChanListener) push(state ProgressState) {
+	if this.Progress != nil {
+		select {
+		case this.Progress <- state:
+		default:
+		}
+	}
+}
+
+// LogListener is a Listener which writes the events to a logger.
+// If Logger is nil, the standard logger is used. The processed files
+// are logged only if Verbose is true.
+type LogListener struct {
+	Logger  *log.Logger
+	Verbose bool
+}
+
+// OnScanStart logs the source
+func (this LogListener) OnScanStart(source string) {
+	this.printf("Scanning %s", source)
+}
+
+// OnFileStart does nothing
+func (this LogListener) OnFileStart(state FileState) {}
+
+// OnFileProgress does nothing
+func (this LogListener) OnFileProgress(state FileState) {}
+
+// OnFileDone logs the processed file
+func (this LogListener) OnFileDone(state FileState) {
+	if !this.Verbose {
+		return
+	}
+	if state.Duplicate {
+		this.printf("[%d/%d] %s (%d bytes, duplicate)", state.Index+1, state.Total, state.Path, state.Size)
+	} else {
+		this.printf("[%d/%d] %s (%d -> %d bytes)", state.Index+1, state.Total, state.Path, state.Size, state.PackedSize)
+	}
+}
+
+// OnError logs the error
+func (this LogListener) OnError(err error, filename string) {
+	this.printf("ERROR: %s (file: %s)", err, filename)
+}
+
+// OnFinish logs the result
+func (this LogListener) OnFinish(result FinishResult) {
+	if result.Err != nil {
+		this.printf("Failed: %s", result.Err)
+		return
+	}
+	this.printf("Finished. Files: %d, size: %d bytes, duplicates: %d (%d bytes)", result.FileCount, result.Size, result.DupCount, result.DupSize)
+}
+
+func (this LogListener) printf(format string, v ...interface{}) {
+	if this.Logger != nil {
+		this.Logger.Printf(format, v...)
+	} else {
+		log.Printf(format, v...)
+	}
+}
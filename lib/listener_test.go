@@ -0,0 +1,308 @@
+package icepacker
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// recordListener records the received events
+type recordListener struct {
+	scans  []string
+	starts []FileState
+	steps  []FileState
+	dones  []FileState
+	errors []error
+	finish []FinishResult
+}
+
+func (this *recordListener) OnScanStart(source string)          { this.scans = append(this.scans, source) }
+func (this *recordListener) OnFileStart(state FileState)        { this.starts = append(this.starts, state) }
+func (this *recordListener) OnFileProgress(state FileState)     { this.steps = append(this.steps, state) }
+func (this *recordListener) OnFileDone(state FileState)         { this.dones = append(this.dones, state) }
+func (this *recordListener) OnError(err error, filename string) { this.errors = append(this.errors, err) }
+func (this *recordListener) OnFinish(result FinishResult)       { this.finish = append(this.finish, result) }
+
+func TestListenerEvents(t *testing.T) {
+
+	Convey("Should call the listener on packing & unpacking", t, func() {
+
+		source, _ := filepath.Abs("testdata/simple")
+		target, _ := filepath.Abs("testdata/packed/listener.pack")
+		unTarget, _ := filepath.Abs("testdata/unpacked/listener")
+
+		listener := &recordListener{}
+		result := Pack(PackSettings{
+			SourceDir:      source,
+			TargetFilename: target,
+			Listener:       listener,
+		})
+
+		So(result.Err, ShouldBeNil)
+		So(listener.scans, ShouldResemble, []string{source})
+		So(listener.starts, ShouldHaveLength, 8)
+		So(listener.dones, ShouldHaveLength, 8)
+		So(listener.errors, ShouldBeEmpty)
+		So(listener.finish, ShouldResemble, []FinishResult{result})
+
+		dupCount := 0
+		for i, state := range listener.dones {
+			So(state.Index, ShouldEqual, i)
+			So(state.Total, ShouldEqual, 8)
+			So(state.Path, ShouldEqual, listener.starts[i].Path)
+			if state.Duplicate {
+				dupCount++
+				So(state.Path, ShouldEqual, "dir2/icon-same.png")
+			} else {
+				So(state.PackedSize, ShouldEqual, state.Size)
+			}
+		}
+		So(dupCount, ShouldEqual, 1)
+
+		listener = &recordListener{}
+		result = Unpack(UnpackSettings{
+			PackFileName: target,
+			TargetDir:    unTarget,
+			Listener:     listener,
+		})
+
+		So(result.Err, ShouldBeNil)
+		So(listener.scans, ShouldResemble, []string{target})
+		So(listener.dones, ShouldHaveLength, 8)
+		So(listener.errors, ShouldBeEmpty)
+		So(listener.finish, ShouldResemble, []FinishResult{result})
+
+		os.Remove(target)
+		os.RemoveAll(unTarget)
+	})
+
+	Convey("Should send the finish result to the listener on error", t, func() {
+
+		listener := &recordListener{}
+		result := Unpack(UnpackSettings{
+			PackFileName: "testdata/packed/notexists.pack",
+			TargetDir:    "testdata/unpacked/notexists",
+			Listener:     listener,
+		})
+
+		So(result.Err, ShouldNotBeNil)
+		So(listener.finish, ShouldResemble, []FinishResult{result})
+
+		os.RemoveAll("testdata/unpacked/notexists")
+	})
+}
+
+func TestFileProgress(t *testing.T) {
+
+	source, _ := filepath.Abs("testdata/progress")
+	target, _ := filepath.Abs("testdata/packed/progress.pack")
+	unTarget, _ := filepath.Abs("testdata/unpacked/progress")
+	os.MkdirAll(source, DEFAULT_PERMISSION)
+	ioutil.WriteFile(filepath.Join(source, "large.dat"), make([]byte, 3*PROGRESS_CHUNK_SIZE+100), 0644)
+	ioutil.WriteFile(filepath.Join(source, "small.txt"), []byte("Small content"), 0644)
+	defer os.RemoveAll(source)
+	defer os.Remove(target)
+	defer os.RemoveAll(unTarget)
+
+	totalSize := int64(3*PROGRESS_CHUNK_SIZE + 100 + 13)
+
+	// checkSteps checks the progress events of the large file
+	checkSteps := func(listener *recordListener) {
+		large := []FileState{}
+		for _, state := range listener.steps {
+			if state.Path == "large.dat" {
+				large = append(large, state)
+			}
+		}
+		So(len(large), ShouldBeGreaterThanOrEqualTo, 4)
+
+		for i, state := range listener.steps {
+			So(state.TotalSize, ShouldEqual, totalSize)
+			if i > 0 {
+				So(state.DoneSize, ShouldBeGreaterThan, listener.steps[i-1].DoneSize)
+			}
+		}
+		So(listener.steps[len(listener.steps)-1].DoneSize, ShouldEqual, totalSize)
+		So(listener.dones[len(listener.dones)-1].DoneSize, ShouldEqual, totalSize)
+	}
+
+	for _, workers := range []int{1, 4} {
+
+		Convey(fmt.Sprintf("Should report the progress inside the files with %d workers", workers), t, func() {
+
+			listener := &recordListener{}
+			result := Pack(PackSettings{
+				SourceDir:      source,
+				TargetFilename: target,
+				Compression:    COMPRESS_GZIP,
+				Workers:        workers,
+				Listener:       listener,
+			})
+			So(result.Err, ShouldBeNil)
+			checkSteps(listener)
+
+			listener = &recordListener{}
+			result = Unpack(UnpackSettings{
+				PackFileName: target,
+				TargetDir:    unTarget,
+				Workers:      workers,
+				Listener:     listener,
+			})
+			So(result.Err, ShouldBeNil)
+			checkSteps(listener)
+		})
+	}
+}
+
+func TestChanListener(t *testing.T) {
+
+	Convey("Should not block if the progress channel is not drained", t, func() {
+
+		source, _ := filepath.Abs("testdata/simple")
+		target, _ := filepath.Abs("testdata/packed/chanlistener.pack")
+		defer os.Remove(target)
+
+		// packAsync packs in a goroutine and returns the result (nil on timeout)
+		packAsync := func(settings PackSettings) *FinishResult {
+			done := make(chan FinishResult, 1)
+			go func() { done <- Pack(settings) }()
+			select {
+			case result := <-done:
+				return &result
+			case <-time.After(10 * time.Second):
+				return nil
+			}
+		}
+
+		chanFinish := make(chan FinishResult, 1)
+		result := packAsync(PackSettings{
+			SourceDir:      source,
+			TargetFilename: target,
+			OnProgress:     make(chan ProgressState),
+			OnFinish:       chanFinish,
+		})
+		So(result, ShouldNotBeNil)
+		So(result.Err, ShouldBeNil)
+		So(<-chanFinish, ShouldResemble, *result)
+
+		// Should not block on the errors either
+		result = packAsync(PackSettings{
+			SourceDir:      filepath.Join(source, "not-exists"),
+			TargetFilename: target,
+			OnProgress:     make(chan ProgressState),
+			OnFinish:       chanFinish,
+		})
+		So(result, ShouldNotBeNil)
+		So(result.Err, ShouldNotBeNil)
+		So((<-chanFinish).Err, ShouldEqual, result.Err)
+	})
+
+	Convey("Should always deliver the finish result to an unbuffered channel", t, func() {
+
+		source, _ := filepath.Abs("testdata/simple")
+		target, _ := filepath.Abs("testdata/packed/chanlistener.pack")
+		defer os.Remove(target)
+
+		// The select loop of the deprecated channels with a slow reader
+		chanProgress := make(chan ProgressState)
+		chanFinish := make(chan FinishResult)
+		go Pack(PackSettings{
+			SourceDir:      source,
+			TargetFilename: target,
+			OnProgress:     chanProgress,
+			OnFinish:       chanFinish,
+		})
+
+		var result FinishResult
+		for done := false; !done; {
+			select {
+			case <-chanProgress:
+				time.Sleep(time.Millisecond)
+			case result = <-chanFinish:
+				done = true
+			case <-time.After(10 * time.Second):
+				t.Fatal("The finish result is not delivered")
+			}
+		}
+		So(result.Err, ShouldBeNil)
+		So(result.FileCount, ShouldEqual, 8)
+	})
+
+	Convey("Should deliver the list result to an unbuffered channel", t, func() {
+
+		source, _ := filepath.Abs("testdata/simple")
+		target, _ := filepath.Abs("testdata/packed/chanlistener-list.pack")
+		defer os.Remove(target)
+		So(Pack(PackSettings{SourceDir: source, TargetFilename: target}).Err, ShouldBeNil)
+
+		chanFinish := make(chan ListResult)
+		go ListPack(ListSettings{PackFileName: target, OnFinish: chanFinish})
+
+		time.Sleep(10 * time.Millisecond)
+		result := <-chanFinish
+		So(result.Err, ShouldBeNil)
+		So(result.FAT.Count, ShouldEqual, 8)
+	})
+
+	Convey("Should push progress states while the channel has free space", t, func() {
+
+		listener := ChanListener{Progress: make(chan ProgressState, 2)}
+		listener.OnFileDone(FileState{Index: 0, Total: 3, Path: "a"})
+		listener.OnFileDone(FileState{Index: 1, Total: 3, Path: "b"})
+		listener.OnFileDone(FileState{Index: 2, Total: 3, Path: "c"})
+
+		So(len(listener.Progress), ShouldEqual, 2)
+		So(<-listener.Progress, ShouldResemble, ProgressState{nil, 3, 1, "a"})
+		So(<-listener.Progress, ShouldResemble, ProgressState{nil, 3, 2, "b"})
+	})
+
+	Convey("Should push the errors while the channel has free space", t, func() {
+
+		listener := ChanListener{Progress: make(chan ProgressState, 1)}
+		listener.OnError(errors.New("failed"), "a")
+		listener.OnError(errors.New("failed"), "b")
+		listener.OnFinish(FinishResult{FileCount: 2})
+
+		So(len(listener.Progress), ShouldEqual, 1)
+		So(<-listener.Progress, ShouldResemble, ProgressState{errors.New("failed"), 0, 0, "a"})
+	})
+
+	Convey("Should push progress states with the deprecated methods", t, func() {
+
+		settings := PackSettings{OnProgress: make(chan ProgressState, 2)}
+		settings.Progress(2, 1, "a")
+		settings.ProgressError(errors.New("failed"), "b")
+
+		So(<-settings.OnProgress, ShouldResemble, ProgressState{nil, 2, 1, "a"})
+		So(<-settings.OnProgress, ShouldResemble, ProgressState{errors.New("failed"), 0, 0, "b"})
+	})
+}
+
+func TestLogListener(t *testing.T) {
+
+	Convey("Should write the events to the logger", t, func() {
+
+		buf := new(bytes.Buffer)
+		listener := LogListener{Logger: log.New(buf, "", 0), Verbose: true}
+
+		listener.OnScanStart("/src")
+		listener.OnFileDone(FileState{Index: 0, Total: 2, Path: "a.txt", Size: 100, PackedSize: 40})
+		listener.OnFileDone(FileState{Index: 1, Total: 2, Path: "b.txt", Size: 100, Duplicate: true})
+		listener.OnError(errors.New("failed"), "c.txt")
+		listener.OnFinish(FinishResult{FileCount: 2, Size: 140, DupCount: 1, DupSize: 40})
+
+		So(buf.String(), ShouldEqual, "Scanning /src\n"+
+			"[1/2] a.txt (100 -> 40 bytes)\n"+
+			"[2/2] b.txt (100 bytes, duplicate)\n"+
+			"ERROR: failed (file: c.txt)\n"+
+			"Finished. Files: 2, size: 140 bytes, duplicates: 1 (40 bytes)\n")
+	})
+}
@@ -0,0 +1,23 @@
+//go:build linux
+// +build linux
+
+package icepacker
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// mmapFile maps the first `size` bytes of the file into the memory (read-only)
+func mmapFile(f *os.File, size int64) ([]byte, error) {
+	if size <= 0 || int64(int(size)) != size {
+		return nil, errors.New("Invalid size for memory mapping!")
+	}
+	return syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+// munmapFile unmaps the memory mapped file
+func munmapFile(data []byte) error {
+	return syscall.Munmap(data)
+}
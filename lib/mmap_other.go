@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package icepacker
+
+import (
+	"errors"
+	"os"
+)
+
+// mmapFile is not supported on this OS
+func mmapFile(f *os.File, size int64) ([]byte, error) {
+	return nil, errors.New("Memory mapping is not supported!")
+}
+
+// munmapFile is not supported on this OS
+func munmapFile(data []byte) error {
+	return nil
+}
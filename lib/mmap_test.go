@@ -0,0 +1,68 @@
+package icepacker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMmapBundle(t *testing.T) {
+
+	source, _ := filepath.Abs("testdata/simple")
+	target, _ := filepath.Abs("testdata/packed/mmap.pack")
+
+	for _, compression := range []byte{COMPRESS_NONE, COMPRESS_GZIP} {
+
+		Convey("Should read the files from the mapped bundle with "+CompressionName(compression), t, func() {
+
+			result := Pack(PackSettings{
+				SourceDir:      source,
+				TargetFilename: target,
+				Compression:    compression,
+			})
+			So(result.Err, ShouldBeNil)
+			defer os.Remove(target)
+
+			bundle, err := OpenBundleMmap(target, nil)
+			So(err, ShouldBeNil)
+			So(bundle.IsMapped(), ShouldEqual, runtime.GOOS == "linux")
+
+			for _, item := range bundle.FAT.Items {
+				content, err := bundle.ReadFile(item)
+				So(err, ShouldBeNil)
+				origContent, _ := ioutil.ReadFile(filepath.Join(source, filepath.FromSlash(item.Path)))
+				So(string(content), ShouldEqual, string(origContent))
+			}
+
+			reader, err := bundle.OpenReaderAt("file1.txt")
+			So(err, ShouldBeNil)
+			buf := make([]byte, 4)
+			_, err = reader.ReadAt(buf, 0)
+			So(err, ShouldBeNil)
+
+			So(bundle.Close(), ShouldBeNil)
+			So(bundle.IsMapped(), ShouldBeFalse)
+		})
+	}
+
+	Convey("Should give error if the FAT points out of the mapped file", t, func() {
+
+		result := Pack(PackSettings{
+			SourceDir:      source,
+			TargetFilename: target,
+		})
+		So(result.Err, ShouldBeNil)
+		defer os.Remove(target)
+
+		bundle, err := OpenBundleMmap(target, nil)
+		So(err, ShouldBeNil)
+		defer bundle.Close()
+
+		_, err = bundle.ReadFile(FATItem{Path: "invalid", Offset: 1 << 30, Size: 10, OrigSize: 10})
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -1,9 +1,11 @@
 package icepacker
 
 import (
+	"io"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sync"
 )
 
 // PackSettings records the settings of the packing
@@ -13,54 +15,80 @@ type PackSettings struct {
 	Includes       string
 	Excludes       string
 	Compression    byte
+	Level          int
+	Rules          []CompressRule
+	Solid          bool
+	Dictionary     bool
+	WindowSize     int
 	Encryption     byte
 	Cipher         CipherSettings
-	OnProgress     chan ProgressState
-	OnFinish       chan FinishResult
+	Workers        int
+	Listener       Listener
+
+	// Storage stores the target bundle (TargetFilename is the name in the storage).
+	// If it's nil, the bundle is created in the local file system.
+	Storage Storage
+
+	// Deprecated: use Listener (or a ChanListener)
+	OnProgress chan ProgressState
+	// Deprecated: use Listener (or a ChanListener)
+	OnFinish chan FinishResult
 }
 
-// Progress push a success ProgressState instance to the OnProgress channel.
-func (this *PackSettings) Progress(total, index int, filename string) {
-	if this.OnProgress != nil {
-		this.OnProgress <- ProgressState{nil, total, index, filename}
+// GetListener returns the Listener of the packing. If it's not set, the
+// OnProgress & OnFinish channels are wrapped to a ChanListener.
+func (this *PackSettings) GetListener() Listener {
+	if this.Listener != nil {
+		return this.Listener
 	}
+	if this.OnProgress != nil || this.OnFinish != nil {
+		return ChanListener{this.OnProgress, this.OnFinish}
+	}
+	return NopListener{}
 }
 
-// ProgressError push an error ProgressState instance to the OnProgress channel.
+// Progress pushes a success ProgressState instance to the OnProgress channel
+// (if it has free space).
+//
+// Deprecated: use Listener
+func (this *PackSettings) Progress(total, index int, filename string) {
+	ChanListener{Progress: this.OnProgress}.push(ProgressState{nil, total, index, filename})
+}
+
+// ProgressError pushes an error ProgressState instance to the OnProgress
+// channel (if it has free space).
+//
+// Deprecated: use Listener
 func (this *PackSettings) ProgressError(err error, filename string) {
-	if this.OnProgress != nil {
-		this.OnProgress <- ProgressState{err, 0, 0, filename}
-	}
+	ChanListener{Progress: this.OnProgress}.push(ProgressState{err, 0, 0, filename})
 }
 
-// Finish returns a success FinishResult instance and put to the OnFinish channel if it's not nil
+// Finish returns a success FinishResult instance and passes it to the listener
 func (this *PackSettings) Finish(err error, fileCount int64, size int64, dupCount int, dupSize int64) FinishResult {
 	ret := FinishResult{err, fileCount, size, dupCount, dupSize}
-	if this.OnFinish != nil {
-		this.OnFinish <- ret
-	}
+	this.GetListener().OnFinish(ret)
 	return ret
 }
 
-// Finish returns an errored FinishResult instance and put to the OnFinish channel if it's not nil
+// FinishError returns an errored FinishResult instance and passes it to the listener
 func (this *PackSettings) FinishError(err error) FinishResult {
 	ret := FinishResult{Err: err}
-	if this.OnFinish != nil {
-		this.OnFinish <- ret
-	}
+	this.GetListener().OnFinish(ret)
 	return ret
 }
 
-// collectFiles walk directories recursively and collect files considering include and exclude filters
-func collectFiles(settings PackSettings) []string {
+// collectFiles walk directories recursively and collect files considering include and exclude filters.
+// Returns the paths and the total size of the collected files.
+func collectFiles(settings PackSettings, listener Listener) ([]string, int64) {
 	files := []string{}
+	totalSize := int64(0)
 
 	filepath.Walk(FixPath(settings.SourceDir), func(path string, f os.FileInfo, err error) error {
 		if err != nil {
 			if f != nil {
-				settings.ProgressError(err, f.Name())
+				listener.OnError(err, f.Name())
 			} else {
-				settings.ProgressError(err, path)
+				listener.OnError(err, path)
 			}
 			return err
 		}
@@ -87,6 +115,7 @@ func collectFiles(settings PackSettings) []string {
 			if needAppend {
 				if !f.IsDir() {
 					files = append(files, path)
+					totalSize += f.Size()
 				}
 			} else {
 				if f.IsDir() {
@@ -97,17 +126,85 @@ func collectFiles(settings PackSettings) []string {
 		}
 		return nil
 	})
-	return files
+	return files, totalSize
+}
+
+// DICT_SAMPLE_COUNT is the maximum count of the sample files of the dictionary
+const DICT_SAMPLE_COUNT = 64
+
+// BuildDictionary builds a shared compression dictionary from a sample of
+// the small files. The sample files are selected evenly from the list, and
+// the beginning of their contents (max. size/4 bytes) is concatenated to a
+// dictionary with max `size` bytes.
+func BuildDictionary(files []string, size int) ([]byte, error) {
+	samples := []string{}
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil && info.Size() > 0 && info.Size() < DICT_FILE_SIZE {
+			samples = append(samples, file)
+		}
+	}
+	if len(samples) == 0 {
+		return nil, nil
+	}
+
+	count := len(samples)
+	if count > DICT_SAMPLE_COUNT {
+		count = DICT_SAMPLE_COUNT
+	}
+
+	dict := make([]byte, 0, size)
+	for i := 0; i < count && len(dict) < size; i++ {
+		// Select the files evenly
+		file := samples[i*len(samples)/count]
+
+		f, err := os.Open(FixPath(file))
+		if err != nil {
+			return nil, err
+		}
+
+		bufSize := size / 4
+		if bufSize > size-len(dict) {
+			bufSize = size - len(dict)
+		}
+		buf := make([]byte, bufSize)
+		n, err := io.ReadFull(f, buf)
+		f.Close()
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		dict = append(dict, buf[:n]...)
+	}
+
+	return dict, nil
 }
 
 // Pack bundles the files of the source directory to the target package file.
 func Pack(settings PackSettings) FinishResult {
 
+	listener := settings.GetListener()
+
 	// Hash the cipher key
 	shaKey := HashingKey(settings.Cipher)
 
+	bundleSettings := BundleSettings{
+		Compression: settings.Compression,
+		Encryption:  settings.Encryption,
+		CipherKey:   shaKey,
+		Level:       settings.Level,
+		Rules:       settings.Rules,
+		Solid:       settings.Solid,
+		WindowSize:  settings.WindowSize,
+	}
+
+	// Check the compressions before creating the bundle
+	if settings.Dictionary {
+		if err := bundleSettings.checkDictionary(); err != nil {
+			return settings.FinishError(err)
+		}
+	}
+
 	// Create a new bundle
-	bundle, err := CreateBundle(settings.TargetFilename, BundleSettings{Compression: settings.Compression, Encryption: settings.Encryption, CipherKey: shaKey})
+	bundle, err := createBundleIn(settings.Storage, settings.TargetFilename, bundleSettings)
 	if err != nil {
 		return settings.FinishError(err)
 	}
@@ -119,46 +216,99 @@ func Pack(settings PackSettings) FinishResult {
 		return settings.FinishError(err)
 	}
 
+	listener.OnScanStart(settings.SourceDir)
+
 	files := []string{}
+	totalSize := int64(0)
 	if sourceInfo.IsDir() {
 		// Collect files from source directory
-		files = collectFiles(settings)
+		files, totalSize = collectFiles(settings, listener)
 	} else {
 		// SourceDir is a file, not a directory
 		files = append(files, settings.SourceDir)
+		totalSize = sourceInfo.Size()
+	}
+
+	// Build the shared dictionary from the files
+	if settings.Dictionary {
+		dict, err := BuildDictionary(files, DICT_SIZE)
+		if err != nil {
+			return settings.FinishError(err)
+		}
+
+		err = bundle.writeDictionary(dict)
+		if err != nil {
+			return settings.FinishError(err)
+		}
 	}
 
 	fileCount := len(files)
 
+	relativePathOf := func(file string) string {
+		if file == settings.SourceDir {
+			// SourceDir is a file
+			return filepath.Base(FixPath(settings.SourceDir))
+		}
+		relativePath, _ := filepath.Rel(FixPath(settings.SourceDir), file)
+		return relativePath
+	}
+
+	// The mutex guards the read bytes and the listener against the workers
+	var mutex sync.Mutex
+	doneSize := int64(0)
+	current := FileState{}
+
+	// progress reports the read bytes of the files (with the current file)
+	progress := func(n int64) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		doneSize += n
+		state := current
+		state.DoneSize = doneSize
+		listener.OnFileProgress(state)
+	}
+
+	addFile := func(i int) (*FATItem, error) {
+		return bundle.addFile(relativePathOf(files[i]), files[i], progress)
+	}
+	if settings.Workers > 1 {
+		addFile = prepareFiles(bundle, files, relativePathOf, settings.Workers, progress)
+	}
+
 	for i, file := range files {
 
 		func(i int, file string) {
 
-			var relativePath string
-			if file == settings.SourceDir {
-				// SourceDir is a file
-				relativePath = filepath.Base(FixPath(settings.SourceDir))
-			} else {
-				relativePath, _ = filepath.Rel(FixPath(settings.SourceDir), file)
-			}
+			relativePath := relativePathOf(file)
 
-			// Update progress state at every 100th file
-			if i%100 == 0 {
-				settings.Progress(fileCount, i, relativePath)
-			}
+			mutex.Lock()
+			current = FileState{Index: i, Total: fileCount, Path: relativePath, TotalSize: totalSize}
+			state := current
+			state.DoneSize = doneSize
+			listener.OnFileStart(state)
+			mutex.Unlock()
+
+			dupCount := bundle.DupCount
+			item, err := addFile(i)
+
+			mutex.Lock()
+			defer mutex.Unlock()
 
-			_, err := bundle.AddFile(relativePath, file)
 			if err != nil {
-				settings.ProgressError(err, relativePath)
+				listener.OnError(err, relativePath)
 				return
 			}
 
+			state.Size = item.OrigSize
+			state.PackedSize = item.Size
+			state.Duplicate = bundle.DupCount > dupCount
+			state.DoneSize = doneSize
+			listener.OnFileDone(state)
+
 		}(i, file)
 	}
 
-	// Update progress state
-	settings.Progress(fileCount, fileCount, "")
-
 	err = bundle.Finalize()
 	if err != nil {
 		return settings.FinishError(err)
@@ -167,3 +317,98 @@ func Pack(settings PackSettings) FinishResult {
 	// Process finished
 	return settings.Finish(nil, bundle.FAT.Count, bundle.Footer.PackSize, bundle.DupCount, bundle.DupSize)
 }
+
+// preparedFile records a read, hashed and transformed file
+type preparedFile struct {
+	item *FATItem
+	blob []byte
+	err  error
+}
+
+// PREPARE_BUFFER_SIZE is the max size of the files which are read ahead by
+// the workers of the parallel packing. A larger file is read alone.
+const PREPARE_BUFFER_SIZE = 64 << 20
+
+// prepareFiles starts `workers` goroutines which read, hash & transform the
+// files concurrently. The returned function adds the i-th prepared file to the
+// bundle, it must be called for every file in order, so the FAT is the same as
+// with sequential packing. At most 2*workers prepared files, and at most
+// PREPARE_BUFFER_SIZE bytes are kept in memory. The large files are not
+// prepared, they are streamed to the bundle when they are added. The read
+// bytes are reported to the progress callback.
+func prepareFiles(bundle *BundleFile, files []string, relativePathOf func(string) string, workers int, progress func(n int64)) func(int) (*FATItem, error) {
+	window := 2 * workers
+	slots := make([]chan preparedFile, window)
+	for i := range slots {
+		slots[i] = make(chan preparedFile, 1)
+	}
+	free := make(chan bool, window)
+	jobs := make(chan int)
+
+	// The reserved bytes of the dispatched files
+	var lock sync.Mutex
+	released := sync.NewCond(&lock)
+	reserved := int64(0)
+	sizes := make([]int64, len(files))
+
+	// Dispatch the files while there is free slot & space
+	go func() {
+		for i, file := range files {
+			free <- true
+
+			size := preparedSize(bundle, relativePathOf(file), file)
+			lock.Lock()
+			for reserved > 0 && reserved+size > PREPARE_BUFFER_SIZE {
+				released.Wait()
+			}
+			reserved += size
+			lock.Unlock()
+
+			sizes[i] = size
+			jobs <- i
+		}
+		close(jobs)
+	}()
+
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range jobs {
+				item, blob, err := bundle.prepareFile(relativePathOf(files[i]), files[i], progress)
+				slots[i%window] <- preparedFile{item, blob, err}
+			}
+		}()
+	}
+
+	return func(i int) (*FATItem, error) {
+		prepared := <-slots[i%window]
+		<-free
+		defer func() {
+			lock.Lock()
+			reserved -= sizes[i]
+			released.Signal()
+			lock.Unlock()
+		}()
+
+		if prepared.err != nil {
+			return nil, prepared.err
+		}
+		if bundle.streamed(prepared.item) {
+			return bundle.addStream(prepared.item, files[i], progress)
+		}
+		return bundle.addItem(prepared.item, prepared.blob)
+	}
+}
+
+// preparedSize returns the count of bytes which are read by prepareFile.
+// The large files are streamed, so they are not read ahead.
+func preparedSize(bundle *BundleFile, relativePath, file string) int64 {
+	info, err := os.Stat(file)
+	if err != nil {
+		return 0
+	}
+	item := FATItem{Path: filepath.ToSlash(relativePath), OrigSize: info.Size()}
+	if bundle.streamed(&item) {
+		return 0
+	}
+	return item.OrigSize
+}
@@ -24,22 +24,26 @@ func TestPackListUnpack(t *testing.T) {
 			includes string
 			excludes string
 		}{
-			{COMPRESS_NONE, ENCRYPT_NONE, 8, 4341, 1, 775, "", ""},
-			{COMPRESS_NONE, ENCRYPT_AES, 8, 4456, 1, 791, "", ""},
-			{COMPRESS_GZIP, ENCRYPT_NONE, 8, 2468, 1, 808, "", ""},
-			{COMPRESS_GZIP, ENCRYPT_AES, 8, 2577, 1, 824, "", ""},
+			{COMPRESS_NONE, ENCRYPT_NONE, 8, 5267, 1, 775, "", ""},
+			{COMPRESS_NONE, ENCRYPT_AES, 8, 5398, 1, 791, "", ""},
+			{COMPRESS_GZIP, ENCRYPT_NONE, 8, 2371, 1, 775, "", ""},
+			{COMPRESS_GZIP, ENCRYPT_AES, 8, 2503, 1, 791, "", ""},
+			{COMPRESS_SNAPPY, ENCRYPT_NONE, 8, 0, 1, 775, "", ""},
+			{COMPRESS_SNAPPY, ENCRYPT_AES, 8, 0, 1, 791, "", ""},
+			{COMPRESS_ZSTD, ENCRYPT_NONE, 8, 0, 1, 775, "", ""},
+			{COMPRESS_ZSTD, ENCRYPT_AES, 8, 0, 1, 791, "", ""},
 
 			// Test includes
-			{COMPRESS_NONE, ENCRYPT_NONE, 4, 2913, 0, 0, ".txt$", ""},
-			{COMPRESS_NONE, ENCRYPT_NONE, 2, 1078, 1, 775, ".png$", ""},
-			{COMPRESS_NONE, ENCRYPT_NONE, 0, 74, 0, 0, ".pdf$", ""},
-			{COMPRESS_NONE, ENCRYPT_NONE, 2, 3462, 0, 0, "dir1", ""},
+			{COMPRESS_NONE, ENCRYPT_NONE, 4, 3413, 0, 0, ".txt$", ""},
+			{COMPRESS_NONE, ENCRYPT_NONE, 2, 1362, 1, 775, ".png$", ""},
+			{COMPRESS_NONE, ENCRYPT_NONE, 0, 82, 0, 0, ".pdf$", ""},
+			{COMPRESS_NONE, ENCRYPT_NONE, 2, 3746, 0, 0, "dir1", ""},
 
 			// Test excludes
-			{COMPRESS_NONE, ENCRYPT_NONE, 4, 1494, 1, 775, "", ".txt$"},
-			{COMPRESS_NONE, ENCRYPT_NONE, 6, 3330, 0, 0, "", ".png$"},
-			{COMPRESS_NONE, ENCRYPT_NONE, 8, 4341, 1, 775, "", ".dat$"},
-			{COMPRESS_NONE, ENCRYPT_NONE, 6, 3912, 0, 0, "", "dir2"},
+			{COMPRESS_NONE, ENCRYPT_NONE, 4, 1987, 1, 775, "", ".txt$"},
+			{COMPRESS_NONE, ENCRYPT_NONE, 6, 4040, 0, 0, "", ".png$"},
+			{COMPRESS_NONE, ENCRYPT_NONE, 8, 5267, 1, 775, "", ".dat$"},
+			{COMPRESS_NONE, ENCRYPT_NONE, 6, 4622, 0, 0, "", "dir2"},
 		}
 
 		for i, test := range tests {
@@ -1,9 +1,14 @@
 package icepacker
 
 import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -48,7 +53,7 @@ func TestPacking(t *testing.T) {
 		So(result, ShouldNotBeNil)
 		So(result.Err, ShouldBeNil)
 		So(result.FileCount, ShouldEqual, 0)
-		So(result.Size, ShouldEqual, 74)
+		So(result.Size, ShouldEqual, 82)
 		So(result.DupCount, ShouldEqual, 0)
 		So(result.DupSize, ShouldEqual, 0)
 
@@ -57,3 +62,334 @@ func TestPacking(t *testing.T) {
 	})
 
 }
+
+func TestParallelPacking(t *testing.T) {
+
+	Convey("Should give the same bundle with more workers", t, func() {
+
+		source, _ := filepath.Abs("testdata/simple")
+
+		pack := func(workers int) *FAT {
+			target, _ := filepath.Abs(fmt.Sprintf("testdata/packed/workers-%d.pack", workers))
+			defer os.Remove(target)
+
+			result := Pack(PackSettings{
+				SourceDir:      source,
+				TargetFilename: target,
+				Compression:    COMPRESS_GZIP,
+				Workers:        workers,
+			})
+			So(result.Err, ShouldBeNil)
+			So(result.FileCount, ShouldEqual, 8)
+			So(result.DupCount, ShouldEqual, 1)
+
+			list := ListPack(ListSettings{PackFileName: target})
+			So(list.Err, ShouldBeNil)
+			return list.FAT
+		}
+
+		sequential := pack(1)
+		for _, workers := range []int{2, 4, 16} {
+			fat := pack(workers)
+			So(fat.Count, ShouldEqual, sequential.Count)
+			So(fat.Size, ShouldEqual, sequential.Size)
+			So(fat.Items, ShouldHaveLength, len(sequential.Items))
+			for i, item := range fat.Items {
+				So(item.Path, ShouldEqual, sequential.Items[i].Path)
+				So(item.Offset, ShouldEqual, sequential.Items[i].Offset)
+				So(item.Size, ShouldEqual, sequential.Items[i].Size)
+			}
+		}
+	})
+
+	Convey("Should limit the size of the files which are read ahead", t, func() {
+
+		// The largest not streamed file, which is added many times
+		source, _ := filepath.Abs("testdata/ahead/frame.dat")
+		os.MkdirAll(filepath.Dir(source), DEFAULT_PERMISSION)
+		ioutil.WriteFile(source, make([]byte, FRAME_SIZE), 0644)
+		defer os.RemoveAll(filepath.Dir(source))
+
+		target, _ := filepath.Abs("testdata/packed/ahead.pack")
+		defer os.Remove(target)
+		bundle, err := CreateBundle(target, BundleSettings{})
+		So(err, ShouldBeNil)
+		defer bundle.Close()
+
+		files := make([]string, 2*PREPARE_BUFFER_SIZE/FRAME_SIZE)
+		for i := range files {
+			files[i] = source
+		}
+		read := int64(0)
+		addFile := prepareFiles(bundle, files, filepath.Base, len(files), func(n int64) { atomic.AddInt64(&read, n) })
+
+		// Wait for the workers
+		time.Sleep(500 * time.Millisecond)
+
+		for i := range files {
+			So(atomic.LoadInt64(&read)-int64(i)*FRAME_SIZE, ShouldBeLessThanOrEqualTo, PREPARE_BUFFER_SIZE)
+			_, err := addFile(i)
+			So(err, ShouldBeNil)
+		}
+		So(bundle.DupCount, ShouldEqual, len(files)-1)
+	})
+
+}
+
+func TestSolidPacking(t *testing.T) {
+
+	// Many small similar JSON files
+	source, _ := filepath.Abs("testdata/solid")
+	os.MkdirAll(source, DEFAULT_PERMISSION)
+	for i := 0; i < 200; i++ {
+		content := fmt.Sprintf(`{"id": %d, "name": "Item %d", "tags": ["small", "json", "file"], "enabled": true}`, i%150, i%150)
+		ioutil.WriteFile(filepath.Join(source, fmt.Sprintf("item-%03d.json", i)), []byte(content), 0644)
+	}
+	defer os.RemoveAll(source)
+
+	pack := func(solid bool, workers int) (FinishResult, string) {
+		target, _ := filepath.Abs(fmt.Sprintf("testdata/packed/solid-%t-%d.pack", solid, workers))
+		result := Pack(PackSettings{
+			SourceDir:      source,
+			TargetFilename: target,
+			Compression:    COMPRESS_GZIP,
+			Encryption:     ENCRYPT_AES,
+			Cipher:         NewCipherSettings("PackSecretKey"),
+			Solid:          solid,
+			Workers:        workers,
+		})
+		So(result.Err, ShouldBeNil)
+		So(result.FileCount, ShouldEqual, 200)
+		So(result.DupCount, ShouldEqual, 50)
+		return result, target
+	}
+
+	Convey("Should compress the small files together", t, func() {
+
+		_, target := pack(false, 1)
+		bundle, err := OpenBundle(target, HashingKey(NewCipherSettings("PackSecretKey")))
+		So(err, ShouldBeNil)
+		dataSize := bundle.FAT.Size
+		bundle.Close()
+		os.Remove(target)
+
+		_, target = pack(true, 1)
+		defer os.Remove(target)
+
+		bundle, err = OpenBundle(target, HashingKey(NewCipherSettings("PackSecretKey")))
+		So(err, ShouldBeNil)
+		defer bundle.Close()
+
+		// The size of the contents (without the FAT)
+		So(bundle.FAT.Size, ShouldBeLessThan, dataSize/4)
+
+		So(bundle.Header.Version, ShouldEqual, VERSION)
+
+		for _, item := range bundle.FAT.Items {
+			So(item.Solid, ShouldBeTrue)
+			So(item.Offset, ShouldEqual, 0)
+			So(item.Size, ShouldEqual, bundle.FAT.Size)
+
+			content, err := bundle.ReadFile(item)
+			So(err, ShouldBeNil)
+			origContent, _ := ioutil.ReadFile(filepath.Join(source, item.Path))
+			So(string(content), ShouldEqual, string(origContent))
+		}
+
+		Convey("the duplicated files point to the same content", func() {
+			item1, _ := bundle.GetItemByPath("item-010.json")
+			item2, _ := bundle.GetItemByPath("item-160.json")
+			So(item2.SolidOffset, ShouldEqual, item1.SolidOffset)
+		})
+
+		Convey("the bundle can be unpacked", func() {
+			unTarget, _ := filepath.Abs("testdata/unpacked/solid")
+			defer os.RemoveAll(unTarget)
+
+			result := Unpack(UnpackSettings{
+				PackFileName: target,
+				TargetDir:    unTarget,
+				Cipher:       NewCipherSettings("PackSecretKey"),
+				Workers:      4,
+			})
+			So(result.Err, ShouldBeNil)
+			So(result.FileCount, ShouldEqual, 200)
+
+			content, _ := ioutil.ReadFile(filepath.Join(unTarget, "item-199.json"))
+			origContent, _ := ioutil.ReadFile(filepath.Join(source, "item-199.json"))
+			So(string(content), ShouldEqual, string(origContent))
+		})
+	})
+
+	Convey("Should give the same solid bundle with more workers", t, func() {
+
+		result, target := pack(true, 1)
+		os.Remove(target)
+
+		parallelResult, target := pack(true, 4)
+		os.Remove(target)
+
+		So(parallelResult.Size, ShouldEqual, result.Size)
+	})
+
+	Convey("Should split the files to segments", t, func() {
+
+		target, _ := filepath.Abs("testdata/packed/segments.pack")
+		defer os.Remove(target)
+
+		bundle, err := CreateBundle(target, BundleSettings{Compression: COMPRESS_GZIP, Solid: true})
+		So(err, ShouldBeNil)
+
+		// Random content which is not compressible
+		random := rand.New(rand.NewSource(1))
+		contents := [][]byte{}
+		for i := 0; i < 5; i++ {
+			content := make([]byte, SOLID_SEGMENT_SIZE/2)
+			random.Read(content)
+			contents = append(contents, content)
+
+			file := filepath.Join(source, fmt.Sprintf("random-%d.bin", i))
+			ioutil.WriteFile(file, content, 0644)
+			_, err := bundle.AddFile(filepath.Base(file), file)
+			So(err, ShouldBeNil)
+		}
+		So(bundle.Finalize(), ShouldBeNil)
+		bundle.Close()
+
+		bundle, err = OpenBundle(target, nil)
+		So(err, ShouldBeNil)
+		defer bundle.Close()
+
+		offsets := []int64{}
+		for i, item := range bundle.FAT.Items {
+			So(item.Solid, ShouldBeTrue)
+			So(item.Compress, ShouldEqual, COMPRESS_NONE)
+			offsets = append(offsets, item.Offset)
+
+			content, err := bundle.ReadFile(item)
+			So(err, ShouldBeNil)
+			So(content, ShouldResemble, contents[i])
+		}
+		So(offsets, ShouldResemble, []int64{0, 0, SOLID_SEGMENT_SIZE, SOLID_SEGMENT_SIZE, 2 * SOLID_SEGMENT_SIZE})
+	})
+}
+
+func TestDictionaryPacking(t *testing.T) {
+
+	// Many small similar JSON files
+	source, _ := filepath.Abs("testdata/dict")
+	os.MkdirAll(source, DEFAULT_PERMISSION)
+	for i := 0; i < 100; i++ {
+		content := fmt.Sprintf(`{"id": %d, "name": "Item %d", "tags": ["small", "json", "file"], "enabled": true}`, i, i)
+		ioutil.WriteFile(filepath.Join(source, fmt.Sprintf("item-%03d.json", i)), []byte(content), 0644)
+	}
+	defer os.RemoveAll(source)
+
+	pack := func(compression byte, dict bool) FinishResult {
+		target, _ := filepath.Abs(fmt.Sprintf("testdata/packed/dict-%t.pack", dict))
+		return Pack(PackSettings{
+			SourceDir:      source,
+			TargetFilename: target,
+			Compression:    compression,
+			Dictionary:     dict,
+			Encryption:     ENCRYPT_AES,
+			Cipher:         NewCipherSettings("PackSecretKey"),
+		})
+	}
+
+	Convey("Should build a dictionary from the sample of files", t, func() {
+
+		files, _ := filepath.Glob(filepath.Join(source, "*.json"))
+		dict, err := BuildDictionary(files, 1024)
+		So(err, ShouldBeNil)
+		So(len(dict), ShouldBeBetweenOrEqual, 900, 1024)
+		So(string(dict), ShouldStartWith, `{"id": 0, "name": "Item 0", "tags"`)
+	})
+
+	Convey("Should compress the small files with the shared dictionary", t, func() {
+
+		target, _ := filepath.Abs("testdata/packed/dict-true.pack")
+		defer os.Remove(target)
+
+		result := pack(COMPRESS_DEFLATE, false)
+		So(result.Err, ShouldBeNil)
+		plainTarget, _ := filepath.Abs("testdata/packed/dict-false.pack")
+		defer os.Remove(plainTarget)
+
+		dictResult := pack(COMPRESS_DEFLATE, true)
+		So(dictResult.Err, ShouldBeNil)
+		So(dictResult.FileCount, ShouldEqual, 100)
+		So(dictResult.Size, ShouldBeLessThan, result.Size*3/4)
+
+		bundle, err := OpenBundle(target, HashingKey(NewCipherSettings("PackSecretKey")))
+		So(err, ShouldBeNil)
+		defer bundle.Close()
+
+		So(bundle.FAT.DictOffset, ShouldEqual, 0)
+		So(bundle.FAT.DictSize, ShouldBeGreaterThan, 0)
+		So(bundle.Settings.Dictionary, ShouldNotBeEmpty)
+
+		for _, item := range bundle.FAT.Items {
+			So(item.Dict, ShouldBeTrue)
+			So(item.Compress, ShouldEqual, COMPRESS_DEFLATE)
+			So(item.Offset, ShouldBeGreaterThanOrEqualTo, bundle.FAT.DictSize)
+
+			content, err := bundle.ReadFile(item)
+			So(err, ShouldBeNil)
+			origContent, _ := ioutil.ReadFile(filepath.Join(source, item.Path))
+			So(string(content), ShouldEqual, string(origContent))
+		}
+
+		Convey("the bundle can be unpacked", func() {
+			unTarget, _ := filepath.Abs("testdata/unpacked/dict")
+			defer os.RemoveAll(unTarget)
+
+			result := Unpack(UnpackSettings{
+				PackFileName: target,
+				TargetDir:    unTarget,
+				Cipher:       NewCipherSettings("PackSecretKey"),
+			})
+			So(result.Err, ShouldBeNil)
+			So(result.FileCount, ShouldEqual, 100)
+
+			content, _ := ioutil.ReadFile(filepath.Join(unTarget, "item-042.json"))
+			origContent, _ := ioutil.ReadFile(filepath.Join(source, "item-042.json"))
+			So(string(content), ShouldEqual, string(origContent))
+		})
+	})
+
+	Convey("Should give error if the compression doesn't support dictionary", t, func() {
+
+		target, _ := filepath.Abs("testdata/packed/dict-true.pack")
+		defer os.Remove(target)
+
+		result := pack(COMPRESS_GZIP, true)
+		So(result.Err, ShouldNotBeNil)
+		So(result.Err.Error(), ShouldEqual, "The gzip compression doesn't support dictionary!")
+
+		// Should not create the bundle
+		_, err := os.Stat(target)
+		So(os.IsNotExist(err), ShouldBeTrue)
+
+		// Should check the compressions of the rules, too
+		result = Pack(PackSettings{
+			SourceDir:      source,
+			TargetFilename: target,
+			Compression:    COMPRESS_DEFLATE,
+			Rules:          []CompressRule{{Pattern: "*.json", Compression: COMPRESS_ZSTD}},
+			Dictionary:     true,
+		})
+		So(result.Err, ShouldNotBeNil)
+		So(result.Err.Error(), ShouldEqual, "The zstd compression doesn't support dictionary!")
+
+		// Should allow storing files without compression
+		result = Pack(PackSettings{
+			SourceDir:      source,
+			TargetFilename: target,
+			Compression:    COMPRESS_DEFLATE,
+			Rules:          CompressedFileRules,
+			Dictionary:     true,
+		})
+		So(result.Err, ShouldBeNil)
+	})
+}
@@ -1,5 +1,7 @@
 package icepacker
 
+import "io"
+
 // ProgressState records the state of packing or unpacking
 type ProgressState struct {
 	Err         error
@@ -8,6 +10,18 @@ type ProgressState struct {
 	CurrentFile string
 }
 
+// FileState records the state of a file while packing or unpacking
+type FileState struct {
+	Index      int
+	Total      int
+	Path       string
+	Size       int64
+	PackedSize int64
+	Duplicate  bool
+	TotalSize  int64
+	DoneSize   int64
+}
+
 // FinishResult records some information about packing or unpacking
 type FinishResult struct {
 	Err       error
@@ -22,3 +36,76 @@ type ListResult struct {
 	Err error
 	FAT *FAT
 }
+
+// Listener receives the events of packing or unpacking. The methods are
+// called synchronously from the goroutine of `Pack` or `Unpack` (or from
+// their workers, but never concurrently), so they should return quickly.
+type Listener interface {
+	// OnScanStart is called before the files are collected from the source
+	OnScanStart(source string)
+
+	// OnFileStart is called before a file is processed
+	OnFileStart(state FileState)
+
+	// OnFileProgress is called while a file is read (packing) or written
+	// (unpacking). The DoneSize of the state contains the processed bytes.
+	OnFileProgress(state FileState)
+
+	// OnFileDone is called after a file is processed. The state contains
+	// the original & packed size of the file and the deduplication result.
+	OnFileDone(state FileState)
+
+	// OnError is called if a file can't be processed
+	OnError(err error, filename string)
+
+	// OnFinish is called once, when the process is finished
+	OnFinish(result FinishResult)
+}
+
+// PROGRESS_CHUNK_SIZE is the max. size of a read or write between two
+// OnFileProgress events
+const PROGRESS_CHUNK_SIZE = 1 << 20
+
+// progressReader reads the reader in chunks and reports the count of read
+// bytes to the progress callback
+type progressReader struct {
+	reader   io.Reader
+	progress func(n int64)
+}
+
+func (this progressReader) Read(p []byte) (int, error) {
+	if len(p) > PROGRESS_CHUNK_SIZE {
+		p = p[:PROGRESS_CHUNK_SIZE]
+	}
+	n, err := this.reader.Read(p)
+	if n > 0 {
+		this.progress(int64(n))
+	}
+	return n, err
+}
+
+// progressWriter writes the writer in chunks and reports the count of
+// written bytes to the progress callback
+type progressWriter struct {
+	writer   io.Writer
+	progress func(n int64)
+}
+
+func (this progressWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		chunk := p[written:]
+		if len(chunk) > PROGRESS_CHUNK_SIZE {
+			chunk = chunk[:PROGRESS_CHUNK_SIZE]
+		}
+		n, err := this.writer.Write(chunk)
+		written += n
+		if n > 0 {
+			this.progress(int64(n))
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,49 @@
+package icepacker
+
+import (
+	"errors"
+	"os"
+)
+
+// ErrNoBundle is returned by OpenSelf if no bundle is appended to the executable
+var ErrNoBundle = errors.New("No bundle is appended to the executable!")
+
+// executable returns the path of the running executable
+var executable = os.Executable
+
+// OpenSelf opens the bundle which is appended to the running executable
+// (e.g. by `icepacker sfx` or `cat app bundle.pack > app`). It returns
+// ErrNoBundle if the executable doesn't contain a bundle. The bundle can't
+// be modified. Use the FS method to access the files as an fs.FS.
+func OpenSelf(cipherKey []byte) (*BundleFile, error) {
+	exe, err := executable()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(exe)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if _, _, err := findBundle(f, info.Size()); err != nil {
+		f.Close()
+		return nil, ErrNoBundle
+	}
+
+	bundle, err := openReader(f, info.Size(), cipherKey, false)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	bundle.Path = exe
+	bundle.File = f
+	return bundle, nil
+}
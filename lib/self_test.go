@@ -0,0 +1,56 @@
+package icepacker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOpenSelf(t *testing.T) {
+
+	Convey("Should return error if no bundle is appended to the executable", t, func() {
+		_, err := OpenSelf(nil)
+		So(err, ShouldEqual, ErrNoBundle)
+	})
+
+	Convey("Should open the bundle appended to the executable", t, func() {
+		source, _ := filepath.Abs("testdata/simple")
+		target, _ := filepath.Abs("testdata/packed/self.bin")
+		defer os.Remove(target)
+
+		f, err := os.Create(target)
+		So(err, ShouldBeNil)
+		f.Write([]byte("\x7fELF executable"))
+
+		key := HashingKey(NewCipherSettings("secret"))
+		bundle, err := NewWriter(f, BundleSettings{Encryption: ENCRYPT_AES, CipherKey: key})
+		So(err, ShouldBeNil)
+		_, err = bundle.AddFile("assets/file1.txt", filepath.Join(source, "file1.txt"))
+		So(err, ShouldBeNil)
+		So(bundle.Finalize(), ShouldBeNil)
+		So(f.Close(), ShouldBeNil)
+
+		executable = func() (string, error) { return target, nil }
+		defer func() { executable = os.Executable }()
+
+		bundle, err = OpenSelf(key)
+		So(err, ShouldBeNil)
+		defer bundle.Close()
+		So(bundle.Path, ShouldEqual, target)
+
+		content, err := bundle.ReadFileFromPath("assets/file1.txt")
+		So(err, ShouldBeNil)
+		expected, _ := ioutil.ReadFile(filepath.Join(source, "file1.txt"))
+		So(content, ShouldResemble, expected)
+
+		_, err = bundle.AddFile("file2.txt", filepath.Join(source, "file2.txt"))
+		So(err.Error(), ShouldEqual, "The bundle is opened read-only!")
+
+		_, err = OpenSelf(HashingKey(NewCipherSettings("wrong")))
+		So(err, ShouldNotBeNil)
+		So(err, ShouldNotEqual, ErrNoBundle)
+	})
+}
@@ -0,0 +1,121 @@
+package icepacker
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// SFX_MAGIC marks the config of the self-extracting executable. The layout
+// of the executable is: stub | config (JSON) | config size (uint32) | SFX_MAGIC | bundle
+const SFX_MAGIC = "IPSFX"
+
+// sfxTrailerSize is the size of the config size & the SFX_MAGIC
+const sfxTrailerSize = 4 + len(SFX_MAGIC)
+
+// SFXConfig is the config of the self-extracting executable
+type SFXConfig struct {
+	// Dir is the default target directory ("" means a new temporary directory)
+	Dir string `json:"dir,omitempty"`
+
+	// Command is the entry command with arguments, which is run in the target
+	// directory after the extraction. The relative path of the command is
+	// resolved in the target directory.
+	Command []string `json:"command,omitempty"`
+}
+
+// findBundle returns the offset & the header of the bundle at the end of the reader
+func findBundle(reader io.ReaderAt, size int64) (int64, *Header, error) {
+	if size < HEADER_SIZE+FOOTER_SIZE {
+		return 0, nil, fmt.Errorf("File is too small! Size: %d", size)
+	}
+
+	footer, err := GetFooter(io.NewSectionReader(reader, size-FOOTER_SIZE, FOOTER_SIZE))
+	if err != nil {
+		return 0, nil, err
+	}
+	if footer.PackSize < HEADER_SIZE+FOOTER_SIZE || footer.PackSize > size {
+		return 0, nil, fmt.Errorf("Invalid pack size! Size: %d", footer.PackSize)
+	}
+
+	begin := size - footer.PackSize
+	header, err := GetHeader(io.NewSectionReader(reader, begin, HEADER_SIZE))
+	if err != nil {
+		return 0, nil, err
+	}
+	return begin, header, nil
+}
+
+// WriteSFX writes the self-extracting executable: the stub, the config and
+// the bundle. The bundle is found at the end of the reader (so only the
+// bundle is copied from a file which contains other content too).
+func WriteSFX(writer io.Writer, stub io.Reader, config SFXConfig, bundle io.ReaderAt, size int64) error {
+	begin, _, err := findBundle(bundle, size)
+	if err != nil {
+		return err
+	}
+
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(writer, stub)
+	if err != nil {
+		return err
+	}
+
+	trailer := make([]byte, sfxTrailerSize)
+	binary.LittleEndian.PutUint32(trailer, uint32(len(configJSON)))
+	copy(trailer[4:], SFX_MAGIC)
+
+	_, err = writer.Write(append(configJSON, trailer...))
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(writer, io.NewSectionReader(bundle, begin, size-begin))
+	return err
+}
+
+// ReadSFX reads the config & the header of the bundle of the self-extracting executable
+func ReadSFX(reader io.ReaderAt, size int64) (*SFXConfig, *Header, error) {
+	begin, header, err := findBundle(reader, size)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if begin < int64(sfxTrailerSize) {
+		return nil, nil, errors.New("The SFX config is not found!")
+	}
+	trailer := make([]byte, sfxTrailerSize)
+	_, err = reader.ReadAt(trailer, begin-int64(sfxTrailerSize))
+	if err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(trailer[4:], []byte(SFX_MAGIC)) {
+		return nil, nil, errors.New("The SFX config is not found!")
+	}
+
+	configSize := int64(binary.LittleEndian.Uint32(trailer))
+	configOffset := begin - int64(sfxTrailerSize) - configSize
+	if configOffset < 0 {
+		return nil, nil, fmt.Errorf("Invalid SFX config size! Size: %d", configSize)
+	}
+
+	configJSON := make([]byte, configSize)
+	_, err = reader.ReadAt(configJSON, configOffset)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	config := new(SFXConfig)
+	err = json.Unmarshal(configJSON, config)
+	if err != nil {
+		return nil, nil, errors.New("Invalid SFX config!")
+	}
+	return config, header, nil
+}
@@ -0,0 +1,80 @@
+package icepacker
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSFX(t *testing.T) {
+
+	source, _ := filepath.Abs("testdata/simple")
+	packFile, _ := filepath.Abs("testdata/packed/sfx.pack")
+	target, _ := filepath.Abs("testdata/packed/sfx.bin")
+	defer os.Remove(packFile)
+	defer os.Remove(target)
+
+	result := Pack(PackSettings{
+		SourceDir:      source,
+		TargetFilename: packFile,
+		Compression:    COMPRESS_GZIP,
+		Encryption:     ENCRYPT_AES,
+		Cipher:         NewCipherSettings("secret"),
+	})
+
+	Convey("Should write & read the self-extracting executable", t, func() {
+		So(result.Err, ShouldBeNil)
+
+		pack, err := os.Open(packFile)
+		So(err, ShouldBeNil)
+		defer pack.Close()
+		info, _ := pack.Stat()
+
+		// The bundle is copied from the end of a file with other content
+		stub := []byte("#!/bin/stub\n")
+		buf := new(bytes.Buffer)
+		err = WriteSFX(buf, bytes.NewReader(stub), SFXConfig{Command: []string{"bin/app", "--verbose"}}, pack, info.Size())
+		So(err, ShouldBeNil)
+		So(bytes.HasPrefix(buf.Bytes(), stub), ShouldBeTrue)
+
+		content := buf.Bytes()
+		config, header, err := ReadSFX(bytes.NewReader(content), int64(len(content)))
+		So(err, ShouldBeNil)
+		So(*config, ShouldResemble, SFXConfig{Command: []string{"bin/app", "--verbose"}})
+		So(header.Encrypt, ShouldEqual, ENCRYPT_AES)
+
+		{
+			// Should wrap a self-extracting executable again with the new config
+			other := new(bytes.Buffer)
+			err = WriteSFX(other, bytes.NewReader(stub), SFXConfig{Dir: "out"}, bytes.NewReader(content), int64(len(content)))
+			So(err, ShouldBeNil)
+			So(other.Len(), ShouldEqual, int(info.Size())+len(stub)+len(`{"dir":"out"}`)+sfxTrailerSize)
+		}
+
+		{
+			// Should open the bundle of the executable
+			So(ioutil.WriteFile(target, content, 0755), ShouldBeNil)
+			bundle, err := OpenBundle(target, HashingKey(NewCipherSettings("secret")))
+			So(err, ShouldBeNil)
+			defer bundle.Close()
+			So(bundle.FAT.Count, ShouldEqual, 8)
+
+			data, err := bundle.ReadFileFromPath("file2.txt")
+			So(err, ShouldBeNil)
+			So(len(data), ShouldEqual, 14)
+		}
+
+		{
+			// Should return error without the SFX config
+			_, _, err := ReadSFX(pack, info.Size())
+			So(err.Error(), ShouldEqual, "The SFX config is not found!")
+
+			err = WriteSFX(new(bytes.Buffer), bytes.NewReader(stub), SFXConfig{}, bytes.NewReader(stub), int64(len(stub)))
+			So(err.Error(), ShouldEqual, "File is too small! Size: 12")
+		}
+	})
+}
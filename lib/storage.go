@@ -0,0 +1,201 @@
+package icepacker
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Storage stores the bundle files, e.g. in a local directory (DirStorage)
+// or in an S3-compatible object store (web.S3Storage)
+type Storage interface {
+	// Open opens the stored file for reading
+	Open(name string) (StorageReader, error)
+
+	// Create creates a new file. It is stored when the writer is committed.
+	Create(name string) (StorageWriter, error)
+}
+
+// StorageReader reads a stored file at offsets. The ReadAt can be called
+// concurrently from many goroutines.
+type StorageReader interface {
+	io.ReaderAt
+	io.Closer
+
+	// Size returns the size of the file
+	Size() int64
+}
+
+// StorageWriter writes a new file. The content is appended, only the header
+// of the bundle is rewritten at the begin of the file.
+type StorageWriter interface {
+	io.WriterAt
+
+	// Commit stores the written file
+	Commit() error
+
+	// Abort drops the written file
+	Abort() error
+}
+
+// CreateBundleStorage creates a new bundle file in the storage. The file is
+// stored by Finalize, if the bundle is closed without Finalize, the file is dropped.
+func CreateBundleStorage(storage Storage, name string, settings BundleSettings) (*BundleFile, error) {
+
+	// Check the codecs & the compression level
+	err := settings.check()
+	if err != nil {
+		return nil, err
+	}
+
+	writer, err := storage.Create(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// The content of the bundle being created is readable only from local files
+	reader, _ := writer.(io.ReaderAt)
+
+	bundle, err := newBundle(writer, reader, settings)
+	if err != nil {
+		writer.Abort()
+		return nil, err
+	}
+
+	bundle.Path = name
+	return bundle, nil
+}
+
+// OpenBundleStorage opens an exist bundle file from the storage. The bundle
+// can't be modified.
+func OpenBundleStorage(storage Storage, name string, cipherKey []byte) (*BundleFile, error) {
+	reader, err := storage.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle, err := openReader(reader, reader.Size(), cipherKey, false)
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+
+	bundle.Path = name
+	bundle.closer = reader
+	return bundle, nil
+}
+
+// createBundleIn creates the bundle in the storage, or in the file system if the storage is nil
+func createBundleIn(storage Storage, name string, settings BundleSettings) (*BundleFile, error) {
+	if storage == nil {
+		return CreateBundle(name, settings)
+	}
+	return CreateBundleStorage(storage, name, settings)
+}
+
+// Opener opens the bundle by name, e.g. from a URL
+type Opener func(name string, cipherKey []byte) (*BundleFile, error)
+
+var (
+	openerLock sync.RWMutex
+	openers    = map[string]Opener{}
+)
+
+// RegisterOpener registers the opener of the bundle names with the prefix
+// (e.g. "https://") for OpenPack. The web package registers the HTTP(S) URLs.
+func RegisterOpener(prefix string, opener Opener) {
+	openerLock.Lock()
+	defer openerLock.Unlock()
+	openers[prefix] = opener
+}
+
+// OpenPack opens the bundle file from the local path ("-" is the STDIN), or
+// with the registered opener of the longest matching prefix of the name
+func OpenPack(name string, cipherKey []byte) (*BundleFile, error) {
+	openerLock.RLock()
+	var opener Opener
+	matched := ""
+	for prefix, o := range openers {
+		if strings.HasPrefix(name, prefix) && len(prefix) > len(matched) {
+			opener, matched = o, prefix
+		}
+	}
+	openerLock.RUnlock()
+
+	if opener != nil {
+		return opener(name, cipherKey)
+	}
+	return OpenBundle(name, cipherKey)
+}
+
+// openBundleIn opens the bundle from the storage, or with OpenPack if the storage is nil
+func openBundleIn(storage Storage, name string, cipherKey []byte) (*BundleFile, error) {
+	if storage == nil {
+		return OpenPack(name, cipherKey)
+	}
+	return OpenBundleStorage(storage, name, cipherKey)
+}
+
+// DirStorage stores the bundle files in a local directory
+type DirStorage struct {
+	Dir string
+}
+
+// Open opens the file of the directory
+func (this DirStorage) Open(name string) (StorageReader, error) {
+	f, err := os.Open(filepath.Join(this.Dir, filepath.FromSlash(name)))
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &dirReader{f, info.Size()}, nil
+}
+
+// Create creates a temporary file in the directory, which is renamed on commit
+func (this DirStorage) Create(name string) (StorageWriter, error) {
+	path := filepath.Join(this.Dir, filepath.FromSlash(name))
+	err := os.MkdirAll(filepath.Dir(path), DEFAULT_PERMISSION)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &dirWriter{f, path}, nil
+}
+
+// dirReader is the StorageReader of DirStorage
+type dirReader struct {
+	*os.File
+	size int64
+}
+
+func (this *dirReader) Size() int64 { return this.size }
+
+// dirWriter is the StorageWriter of DirStorage
+type dirWriter struct {
+	*os.File
+	path string
+}
+
+func (this *dirWriter) Commit() error {
+	err := this.File.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(this.File.Name(), this.path)
+}
+
+func (this *dirWriter) Abort() error {
+	this.File.Close()
+	return os.Remove(this.File.Name())
+}
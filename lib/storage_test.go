@@ -0,0 +1,79 @@
+package icepacker
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDirStorage(t *testing.T) {
+
+	source, _ := filepath.Abs("testdata/simple")
+
+	Convey("Should create the bundle in the directory storage", t, func() {
+		dir, _ := filepath.Abs("testdata/packed/storage")
+		defer os.RemoveAll(dir)
+		storage := DirStorage{Dir: dir}
+
+		result := Pack(PackSettings{SourceDir: source, TargetFilename: "a/simple.pack", Storage: storage})
+		So(result.Err, ShouldBeNil)
+
+		files, _ := filepath.Glob(filepath.Join(dir, "a", "*"))
+		sort.Strings(files)
+		So(files, ShouldResemble, []string{filepath.Join(dir, "a", "simple.pack")})
+
+		list := ListPack(ListSettings{PackFileName: "a/simple.pack", Storage: storage})
+		So(list.Err, ShouldBeNil)
+		So(list.FAT.Count, ShouldEqual, 8)
+		So(strings.HasPrefix(list.FAT.Items[0].Path, "."), ShouldBeTrue)
+	})
+}
+
+func TestRegisterOpener(t *testing.T) {
+
+	// Restore the registered openers after the test
+	openerLock.Lock()
+	saved := openers
+	openers = map[string]Opener{}
+	for prefix, opener := range saved {
+		openers[prefix] = opener
+	}
+	openerLock.Unlock()
+	defer func() {
+		openerLock.Lock()
+		openers = saved
+		openerLock.Unlock()
+	}()
+
+	Convey("Should open the names with the opener of the longest prefix", t, func() {
+		opened := ""
+		opener := func(prefix string) Opener {
+			return func(name string, cipherKey []byte) (*BundleFile, error) {
+				opened = prefix + " " + name
+				return nil, errors.New("Test opener!")
+			}
+		}
+		RegisterOpener("test://", opener("test"))
+		RegisterOpener("test://long/", opener("long"))
+
+		_, err := OpenPack("test://a.pack", nil)
+		So(err.Error(), ShouldEqual, "Test opener!")
+		So(opened, ShouldEqual, "test test://a.pack")
+
+		_, err = OpenPack("test://long/a.pack", nil)
+		So(err, ShouldNotBeNil)
+		So(opened, ShouldEqual, "long test://long/a.pack")
+
+		// Should open the local files without opener
+		opened = ""
+		bundle, err := OpenPack("testdata/packed/not-exists.pack", nil)
+		So(err, ShouldNotBeNil)
+		So(bundle, ShouldBeNil)
+		So(opened, ShouldEqual, "")
+	})
+}
@@ -0,0 +1,144 @@
+package icepacker
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// NewWriter creates a new bundle which is written to the writer from its
+// current position. The header is rewritten by Finalize, so the writer must
+// be seekable. If the writer is an io.ReaderAt too (e.g. *os.File or
+// *MemBuffer), the added files can be read before Finalize.
+func NewWriter(writer io.WriteSeeker, settings BundleSettings) (*BundleFile, error) {
+
+	// Check the codecs & the compression level
+	err := settings.check()
+	if err != nil {
+		return nil, err
+	}
+
+	base, err := writer.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+
+	var reader io.ReaderAt
+	if readerAt, ok := writer.(io.ReaderAt); ok {
+		reader = &offsetReaderAt{readerAt, base}
+	}
+
+	return newBundle(&seekWriterAt{writer: writer, base: base}, reader, settings)
+}
+
+// NewReader opens the bundle from the reader with the given size. The
+// bundle is at the end of the content, so it can be behind other content
+// (e.g. an executable). The bundle can't be modified.
+func NewReader(reader io.ReaderAt, size int64, cipherKey []byte) (*BundleFile, error) {
+	return openReader(reader, size, cipherKey, false)
+}
+
+// seekWriterAt is an io.WriterAt of an io.WriteSeeker from the base position
+type seekWriterAt struct {
+	lock   sync.Mutex
+	writer io.WriteSeeker
+	base   int64
+}
+
+func (this *seekWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	_, err := this.writer.Seek(this.base+off, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	return this.writer.Write(p)
+}
+
+// offsetReaderAt is an io.ReaderAt from the base offset of the reader
+type offsetReaderAt struct {
+	reader io.ReaderAt
+	base   int64
+}
+
+func (this *offsetReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	return this.reader.ReadAt(p, this.base+off)
+}
+
+// MemBuffer is an in-memory io.WriteSeeker & io.ReaderAt to create bundles
+// in the memory with NewWriter
+type MemBuffer struct {
+	lock sync.RWMutex
+	buf  []byte
+	pos  int64
+}
+
+// Write writes the content at the current position and extends the buffer
+func (this *MemBuffer) Write(p []byte) (int, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	end := this.pos + int64(len(p))
+	if end > int64(len(this.buf)) {
+		if end > int64(cap(this.buf)) {
+			buf := make([]byte, end, 2*end)
+			copy(buf, this.buf)
+			this.buf = buf
+		}
+		this.buf = this.buf[:end]
+	}
+	copy(this.buf[this.pos:], p)
+	this.pos = end
+	return len(p), nil
+}
+
+// Seek sets the position of the next Write
+func (this *MemBuffer) Seek(offset int64, whence int) (int64, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += this.pos
+	case io.SeekEnd:
+		offset += int64(len(this.buf))
+	}
+	if offset < 0 {
+		return 0, errors.New("Negative position!")
+	}
+	this.pos = offset
+	return offset, nil
+}
+
+// ReadAt reads the content of the buffer at offset `off`
+func (this *MemBuffer) ReadAt(p []byte, off int64) (int, error) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	if off < 0 {
+		return 0, errors.New("Negative offset!")
+	}
+	if off >= int64(len(this.buf)) {
+		return 0, io.EOF
+	}
+	n := copy(p, this.buf[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Bytes returns the content of the buffer. It is valid until the next Write.
+func (this *MemBuffer) Bytes() []byte {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return this.buf
+}
+
+// Len returns the size of the content
+func (this *MemBuffer) Len() int {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return len(this.buf)
+}
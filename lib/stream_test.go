@@ -0,0 +1,82 @@
+package icepacker
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStreams(t *testing.T) {
+
+	source, _ := filepath.Abs("testdata/simple")
+	file1, _ := ioutil.ReadFile(filepath.Join(source, "file1.txt"))
+	file3, _ := ioutil.ReadFile(filepath.Join(source, "dir1", "file3.txt"))
+
+	Convey("Should create & read the bundle in the memory", t, func() {
+		buf := new(MemBuffer)
+		bundle, err := NewWriter(buf, BundleSettings{Compression: COMPRESS_GZIP})
+		So(err, ShouldBeNil)
+
+		_, err = bundle.AddFile("file1.txt", filepath.Join(source, "file1.txt"))
+		So(err, ShouldBeNil)
+		_, err = bundle.AddFile("dir1/file3.txt", filepath.Join(source, "dir1", "file3.txt"))
+		So(err, ShouldBeNil)
+
+		// Should read the added file before finalize
+		content, err := bundle.ReadFileFromPath("dir1/file3.txt")
+		So(err, ShouldBeNil)
+		So(content, ShouldResemble, file3)
+
+		So(bundle.Finalize(), ShouldBeNil)
+		So(bundle.Close(), ShouldBeNil)
+		So(string(buf.Bytes()[:MAGIC_SIZE]), ShouldEqual, MagicBytes)
+
+		{
+			// Should read the bundle from a bytes.Reader
+			bundle, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()), nil)
+			So(err, ShouldBeNil)
+			defer bundle.Close()
+
+			So(bundle.FAT.Count, ShouldEqual, 2)
+			content, err := bundle.ReadFileFromPath("file1.txt")
+			So(err, ShouldBeNil)
+			So(content, ShouldResemble, file1)
+
+			_, err = bundle.AddFile("file2.txt", filepath.Join(source, "file2.txt"))
+			So(err.Error(), ShouldEqual, "The bundle is opened read-only!")
+		}
+	})
+
+	Convey("Should write the bundle behind other content", t, func() {
+		target, _ := filepath.Abs("testdata/packed/stream.bin")
+		defer os.Remove(target)
+
+		f, err := os.Create(target)
+		So(err, ShouldBeNil)
+		defer f.Close()
+
+		prefix := []byte("#!/bin/sh\nexit 0\n")
+		f.Write(prefix)
+		bundle, err := NewWriter(f, BundleSettings{Encryption: ENCRYPT_AES, CipherKey: HashingKey(NewCipherSettings("secret"))})
+		So(err, ShouldBeNil)
+		_, err = bundle.AddFile("file1.txt", filepath.Join(source, "file1.txt"))
+		So(err, ShouldBeNil)
+		So(bundle.Finalize(), ShouldBeNil)
+
+		info, _ := f.Stat()
+		bundle, err = NewReader(f, info.Size(), HashingKey(NewCipherSettings("secret")))
+		So(err, ShouldBeNil)
+		So(bundle.Footer.PackSize, ShouldEqual, info.Size()-int64(len(prefix)))
+
+		content, err := bundle.ReadFileFromPath("file1.txt")
+		So(err, ShouldBeNil)
+		So(content, ShouldResemble, file1)
+
+		_, err = NewReader(bytes.NewReader([]byte("not a bundle")), 12, nil)
+		So(err, ShouldNotBeNil)
+	})
+}
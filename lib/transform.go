@@ -3,6 +3,8 @@ package icepacker
 import (
 	"bytes"
 	"compress/gzip"
+	"runtime"
+	"sync"
 
 	"fmt"
 
@@ -43,17 +45,17 @@ func HashingKey(settings CipherSettings) []byte {
 }
 
 // decrypt is decrypting the content with the key
-func decrypt(ciphertext []byte, key []byte) []byte {
+func decrypt(ciphertext []byte, key []byte) ([]byte, error) {
 	// Create the AES cipher
 	block, err := aes.NewCipher(key)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	// Before even testing the decryption,
 	// if the text is too small, then it is incorrect
 	if len(ciphertext) < aes.BlockSize {
-		panic(fmt.Errorf("Text is too short: %d", len(ciphertext)))
+		return nil, fmt.Errorf("Text is too short: %d", len(ciphertext))
 	}
 
 	// Get the 16 byte IV
@@ -65,18 +67,19 @@ func decrypt(ciphertext []byte, key []byte) []byte {
 	// Return a decrypted stream
 	stream := cipher.NewCFBDecrypter(block, iv)
 
-	// Decrypt bytes from ciphertext
-	stream.XORKeyStream(ciphertext, ciphertext)
+	// Decrypt bytes from ciphertext to a new slice (the ciphertext is not modified)
+	plaintext := make([]byte, len(ciphertext))
+	stream.XORKeyStream(plaintext, ciphertext)
 
-	return ciphertext
+	return plaintext, nil
 }
 
 // encrypt is encrypting the content with the key
-func encrypt(plaintext []byte, key []byte) []byte {
+func encrypt(plaintext []byte, key []byte) ([]byte, error) {
 	// Create the AES cipher
 	block, err := aes.NewCipher(key)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	// Empty array of 16 + plaintext length
@@ -88,7 +91,7 @@ func encrypt(plaintext []byte, key []byte) []byte {
 
 	// Write 16 rand bytes to fill iv
 	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	// Return an encrypted stream
@@ -97,93 +100,146 @@ func encrypt(plaintext []byte, key []byte) []byte {
 	// Encrypt bytes from plaintext to ciphertext
 	stream.XORKeyStream(ciphertext[aes.BlockSize:], plaintext)
 
-	return ciphertext
+	return ciphertext, nil
 }
 
-// compress is compress the data with GZIP
-func compress(data []byte) []byte {
+// compress is compress the data with GZIP with the best compression
+func compress(data []byte) ([]byte, error) {
+	return compressLevel(data, LEVEL_DEFAULT)
+}
+
+// compressLevel is compress the data with GZIP. The large contents are split
+// to frames by the bundle (see writeFrames), so it is one gzip member.
+func compressLevel(data []byte, level int) ([]byte, error) {
+	if level == LEVEL_DEFAULT {
+		level = gzip.BestCompression
+	}
+
 	var b bytes.Buffer
 
-	gz, err := gzip.NewWriterLevel(&b, gzip.BestCompression)
+	gz, err := gzip.NewWriterLevel(&b, level)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	if _, err := gz.Write(data); err != nil {
-		panic(err)
-	}
-
-	if err := gz.Flush(); err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	if err := gz.Close(); err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	return b.Bytes()
+	return b.Bytes(), nil
 }
 
-// decompress is decompress the data with GUNZIP
-func decompress(data []byte) []byte {
-	b := bytes.NewReader(data)
-
-	gz, err := gzip.NewReader(b)
+// decompress is decompress the data with GUNZIP. It reads every member of
+// a multistream gzip (e.g. the blocks of the older bundles) till the EOF,
+// so the checksums of members are verified. The result is allocated by the
+// read content, not by the (untrusted) sizes in the data.
+func decompress(data []byte) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	defer gz.Close()
 
-	content, err := ioutil.ReadAll(gz)
-	if err != nil {
-		panic(err)
+	return ioutil.ReadAll(gz)
+}
+
+// parallel calls the fn with 0..count-1 indexes on more goroutines
+// (max. the count of CPUs) and waits for them.
+func parallel(count int, fn func(i int)) {
+	workers := runtime.NumCPU()
+	if workers > count {
+		workers = count
+	}
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				fn(i)
+			}
+		}()
 	}
 
-	return []byte(content)
+	for i := 0; i < count; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
 }
 
 // TransformPack is transform the content of file to the package (encrypt, compress)
+// with the registered codecs
 func TransformPack(data []byte, compression byte, encryption byte, key []byte) ([]byte, error) {
-	var res []byte
+	return transformPackLevel(data, compression, LEVEL_DEFAULT, encryption, key)
+}
 
+// transformPackLevel is transform the content of file with the level of compression
+func transformPackLevel(data []byte, compression byte, level int, encryption byte, key []byte) ([]byte, error) {
 	if len(data) == 0 {
 		return data, nil
 	}
 
-	// Compression
-	if compression == COMPRESS_GZIP {
-		res = compress(data)
-	} else {
-		res = data
+	compressor, err := GetCompressor(compression)
+	if err != nil {
+		return nil, err
+	}
+	cipher, err := GetCipher(encryption)
+	if err != nil {
+		return nil, err
 	}
 
-	// Encryption
-	if encryption == ENCRYPT_AES {
-		res = encrypt(res, key)
+	// Compression
+	res, err := compressor.Compress(data, level)
+	if err != nil {
+		return nil, err
 	}
 
-	return res, nil
+	// Encryption
+	return cipher.Encrypt(res, key)
 }
 
 // TransformUnpack is transform back the transformed file content to the real file
+// with the registered codecs
 func TransformUnpack(data []byte, compression byte, encryption byte, key []byte) ([]byte, error) {
-	var res []byte
+	return transformUnpackDict(data, compression, nil, encryption, key)
+}
 
+// transformUnpackDict is transform back the transformed file content which
+// is compressed with the shared dictionary (if dict is not nil)
+func transformUnpackDict(data []byte, compression byte, dict []byte, encryption byte, key []byte) ([]byte, error) {
 	if len(data) == 0 {
 		return data, nil
 	}
 
-	// Encryption
-	if encryption == ENCRYPT_AES {
-		res = decrypt(data, key)
-	} else {
-		res = data
+	compressor, err := GetCompressor(compression)
+	if err != nil {
+		return nil, err
+	}
+	cipher, err := GetCipher(encryption)
+	if err != nil {
+		return nil, err
 	}
 
-	// Compression
-	if compression == COMPRESS_GZIP {
-		res = decompress(res)
+	// Encryption
+	res, err := cipher.Decrypt(data, key)
+	if err != nil {
+		return nil, err
 	}
 
-	return res, nil
+	// Compression
+	if dict != nil {
+		dictCompressor, ok := compressor.(DictCompressor)
+		if !ok {
+			return nil, fmt.Errorf("The %s compression doesn't support dictionary!", compressor.Name())
+		}
+		return dictCompressor.DecompressDict(res, dict)
+	}
+	return compressor.Decompress(res)
 }
@@ -1,6 +1,9 @@
 package icepacker
 
 import (
+	"bytes"
+	"compress/gzip"
+	"math/rand"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -27,22 +30,87 @@ func TestTransformEncryptDecrypt(t *testing.T) {
 	origText := "Original plain text"
 
 	Convey("Should equal the encrypted & decrypted text", t, func() {
-		cipher := encrypt([]byte(origText), key)
+		cipher, err := encrypt([]byte(origText), key)
+		So(err, ShouldBeNil)
 		So(string(cipher), ShouldNotEqual, origText)
-		text := decrypt(cipher, key)
+		text, err := decrypt(cipher, key)
+		So(err, ShouldBeNil)
 		So(string(text), ShouldEqual, origText)
 	})
+
+	Convey("Should return error if the text is too short", t, func() {
+		_, err := decrypt([]byte("short"), key)
+		So(err.Error(), ShouldEqual, "Text is too short: 5")
+
+		_, err = encrypt([]byte(origText), []byte("short"))
+		So(err, ShouldNotBeNil)
+	})
 }
 
 func TestTransformCompressDecompress(t *testing.T) {
 	origText := "Original plain data"
 
 	Convey("Should equal the compressed & decompressed text", t, func() {
-		compressed := compress([]byte(origText))
+		compressed, err := compress([]byte(origText))
+		So(err, ShouldBeNil)
 		So(string(compressed), ShouldNotEqual, origText)
-		text := decompress(compressed)
+		text, err := decompress(compressed)
+		So(err, ShouldBeNil)
 		So(string(text), ShouldEqual, origText)
 	})
+
+	Convey("Should return error if the data is not gzip", t, func() {
+		_, err := decompress([]byte("not a gzip content"))
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestTransformCompressLarge(t *testing.T) {
+
+	// Compressible content which is larger than a frame
+	random := rand.New(rand.NewSource(1))
+	origData := make([]byte, 3*FRAME_SIZE+12345)
+	for i := range origData {
+		origData[i] = "abcdefgh"[random.Intn(8)]
+	}
+
+	Convey("Should compress & decompress large data", t, func() {
+		compressed, err := compress(origData)
+		So(err, ShouldBeNil)
+		So(len(compressed), ShouldBeLessThan, len(origData))
+
+		content, err := decompress(compressed)
+		So(err, ShouldBeNil)
+		So(content, ShouldResemble, origData)
+	})
+
+	Convey("Should decompress a multistream gzip", t, func() {
+		buf := new(bytes.Buffer)
+		for i := 0; i < 3; i++ {
+			gz := gzip.NewWriter(buf)
+			gz.Write(origData[i*FRAME_SIZE : (i+1)*FRAME_SIZE])
+			gz.Close()
+		}
+
+		content, err := decompress(buf.Bytes())
+		So(err, ShouldBeNil)
+		So(content, ShouldResemble, origData[:3*FRAME_SIZE])
+	})
+
+	Convey("Should return error if the checksum is wrong", t, func() {
+		compressed, _ := compress([]byte("small data"))
+		compressed[len(compressed)-8] ^= 0xff
+
+		_, err := decompress(compressed)
+		So(err, ShouldEqual, gzip.ErrChecksum)
+	})
+
+	Convey("Should return error if the data is truncated", t, func() {
+		compressed, _ := compress(origData)
+
+		_, err := decompress(compressed[:len(compressed)/2])
+		So(err, ShouldNotBeNil)
+	})
 }
 
 func TestTransformPack(t *testing.T) {
@@ -70,6 +138,12 @@ func TestTransformPack(t *testing.T) {
 				{COMPRESS_NONE, ENCRYPT_AES},
 				{COMPRESS_GZIP, ENCRYPT_NONE},
 				{COMPRESS_GZIP, ENCRYPT_AES},
+				{COMPRESS_SNAPPY, ENCRYPT_NONE},
+				{COMPRESS_SNAPPY, ENCRYPT_AES},
+				{COMPRESS_ZSTD, ENCRYPT_NONE},
+				{COMPRESS_ZSTD, ENCRYPT_AES},
+				{COMPRESS_DEFLATE, ENCRYPT_NONE},
+				{COMPRESS_DEFLATE, ENCRYPT_AES},
 			}
 
 			for _, test := range tests {
@@ -86,4 +160,13 @@ func TestTransformPack(t *testing.T) {
 
 	})
 
+	Convey("Should return error with wrong key", t, func() {
+		transformed, err := TransformPack([]byte(origText), COMPRESS_GZIP, ENCRYPT_AES, key)
+		So(err, ShouldBeNil)
+
+		wrongKey := HashingKey(CipherSettings{Key: "wrong", Iteration: 500})
+		_, err = TransformUnpack(transformed, COMPRESS_GZIP, ENCRYPT_AES, wrongKey)
+		So(err, ShouldNotBeNil)
+	})
+
 }
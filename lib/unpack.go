@@ -1,8 +1,10 @@
 package icepacker
 
 import (
+	"io"
 	"os"
 	"path/filepath"
+	"sync"
 )
 
 // UnpackSettings records the settings of the unpacking
@@ -12,45 +14,66 @@ type UnpackSettings struct {
 	Includes     string
 	Excludes     string
 	Cipher       CipherSettings
-	OnProgress   chan ProgressState
-	OnFinish     chan FinishResult
+	Workers      int
+	Listener     Listener
+
+	// Storage stores the bundle (PackFileName is the name in the storage).
+	// If it's nil, the bundle is opened with OpenPack.
+	Storage Storage
+
+	// Deprecated: use Listener (or a ChanListener)
+	OnProgress chan ProgressState
+	// Deprecated: use Listener (or a ChanListener)
+	OnFinish chan FinishResult
 }
 
-// Progress push a success ProgressState instance to the OnProgress channel.
-func (this *UnpackSettings) Progress(total, index int, filename string) {
-	if this.OnProgress != nil {
-		this.OnProgress <- ProgressState{nil, total, index, filename}
+// GetListener returns the Listener of the unpacking. If it's not set, the
+// OnProgress & OnFinish channels are wrapped to a ChanListener.
+func (this *UnpackSettings) GetListener() Listener {
+	if this.Listener != nil {
+		return this.Listener
 	}
+	if this.OnProgress != nil || this.OnFinish != nil {
+		return ChanListener{this.OnProgress, this.OnFinish}
+	}
+	return NopListener{}
+}
+
+// Progress pushes a success ProgressState instance to the OnProgress channel
+// (if it has free space).
+//
+// Deprecated: use Listener
+func (this *UnpackSettings) Progress(total, index int, filename string) {
+	ChanListener{Progress: this.OnProgress}.push(ProgressState{nil, total, index, filename})
 }
 
-// ProgressError push an error ProgressState instance to the OnProgress channel.
+// ProgressError pushes an error ProgressState instance to the OnProgress
+// channel (if it has free space).
+//
+// Deprecated: use Listener
 func (this *UnpackSettings) ProgressError(err error, filename string) {
-	if this.OnProgress != nil {
-		this.OnProgress <- ProgressState{err, 0, 0, filename}
-	}
+	ChanListener{Progress: this.OnProgress}.push(ProgressState{err, 0, 0, filename})
 }
 
-// Finish returns a success FinishResult instance and put to the OnFinish channel if it's not nil
+// Finish returns a success FinishResult instance and passes it to the listener
 func (this *UnpackSettings) Finish(err error, fileCount int64, size int64, dupCount int, dupSize int64) FinishResult {
 	ret := FinishResult{err, fileCount, size, dupCount, dupSize}
-	if this.OnFinish != nil {
-		this.OnFinish <- ret
-	}
+	this.GetListener().OnFinish(ret)
 	return ret
 }
 
-// Finish returns an errored FinishResult instance and put to the OnFinish channel if it's not nil
+// FinishError returns an errored FinishResult instance and passes it to the listener
 func (this *UnpackSettings) FinishError(err error) FinishResult {
 	ret := FinishResult{Err: err}
-	if this.OnFinish != nil {
-		this.OnFinish <- ret
-	}
+	this.GetListener().OnFinish(ret)
 	return ret
 }
 
 // Unpack extract files from the package file
 func Unpack(settings UnpackSettings) FinishResult {
 
+	listener := settings.GetListener()
+
 	// Hash the cipher key
 	shaKey := HashingKey(settings.Cipher)
 
@@ -60,8 +83,10 @@ func Unpack(settings UnpackSettings) FinishResult {
 		return settings.FinishError(err)
 	}
 
+	listener.OnScanStart(settings.PackFileName)
+
 	// Open the bundle file
-	bundle, err := OpenBundle(settings.PackFileName, shaKey)
+	bundle, err := openBundleIn(settings.Storage, settings.PackFileName, shaKey)
 	if err != nil {
 		return settings.FinishError(err)
 	}
@@ -70,59 +95,111 @@ func Unpack(settings UnpackSettings) FinishResult {
 	// 6. Restore files from package
 	totalSize := int64(0)
 	fileCount := len(bundle.FAT.Items)
-	for i, item := range bundle.FAT.Items {
+	allSize := int64(0)
+	for _, item := range bundle.FAT.Items {
+		allSize += item.OrigSize
+	}
+	doneSize := int64(0)
 
-		func(i int, item FATItem) {
+	workers := settings.Workers
+	if workers < 1 {
+		workers = 1
+	}
 
-			fullPath := filepath.Join(settings.TargetDir, filepath.FromSlash(item.Path))
-			dir := FixPath(filepath.Dir(fullPath))
+	// The mutex guards the counters and the listener against the workers
+	var mutex sync.Mutex
 
-			// Create directories by fullPath
-			err = os.MkdirAll(dir, DEFAULT_PERMISSION)
-			if err != nil {
-				settings.ProgressError(err, dir)
-				return
-			}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
 
-			// Update progress state
-			if i%100 == 0 {
-				settings.Progress(fileCount, i, item.Path)
-			}
+			for i := range jobs {
+				item := bundle.FAT.Items[i]
 
-			// Create new target file
-			target, err := os.OpenFile(FixPath(fullPath), os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.FileMode(item.Perm))
-			if err != nil {
-				settings.ProgressError(err, item.Path)
-				return
-			}
-			defer target.Close()
+				mutex.Lock()
+				state := FileState{Index: i, Total: fileCount, Path: item.Path, PackedSize: item.Size, TotalSize: allSize, DoneSize: doneSize}
+				listener.OnFileStart(state)
+				mutex.Unlock()
 
-			// If not empty
-			if item.Size > 0 {
+				// progress reports the written bytes of the file
+				progress := func(n int64) {
+					mutex.Lock()
+					defer mutex.Unlock()
 
-				// Read content of file from bundle
-				content, err := bundle.ReadFile(item)
-				if err != nil {
-					settings.ProgressError(err, item.Path)
-					return
+					doneSize += n
+					state.DoneSize = doneSize
+					listener.OnFileProgress(state)
 				}
 
-				// Write the content to the target file
-				_, err = target.Write(content)
+				size, err := restoreFile(bundle, item, settings.TargetDir, progress)
+
+				mutex.Lock()
 				if err != nil {
-					settings.ProgressError(err, item.Path)
-					return
+					listener.OnError(err, item.Path)
+				} else {
+					totalSize += size
+					state.Size = size
+					state.DoneSize = doneSize
+					listener.OnFileDone(state)
 				}
-
-				totalSize += int64(len(content))
+				mutex.Unlock()
 			}
-		}(i, item)
-
+		}()
 	}
 
-	// Update progress to 100%
-	settings.Progress(fileCount, fileCount, "")
+	for i := range bundle.FAT.Items {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
 
 	// Process finished
 	return settings.Finish(nil, int64(fileCount), totalSize, 0, 0)
 }
+
+// restoreFile extracts the file of the item from the bundle to the target
+// directory. The content is decoded & written in chunks, and the count of
+// written bytes is reported to the progress callback. Returns the size of
+// the written content.
+func restoreFile(bundle *BundleFile, item FATItem, targetDir string, progress func(n int64)) (int64, error) {
+
+	fullPath := filepath.Join(targetDir, filepath.FromSlash(item.Path))
+	dir := FixPath(filepath.Dir(fullPath))
+
+	// Create directories by fullPath
+	err := os.MkdirAll(dir, DEFAULT_PERMISSION)
+	if err != nil {
+		return 0, err
+	}
+
+	// Create new target file
+	target, err := os.OpenFile(FixPath(fullPath), os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.FileMode(item.Perm))
+	if err != nil {
+		return 0, err
+	}
+	defer target.Close()
+
+	// If empty
+	if item.Size == 0 {
+		return 0, nil
+	}
+
+	// Copy the decoded content of file from bundle to the target file
+	bufSize := int64(PROGRESS_CHUNK_SIZE)
+	if item.OrigSize < bufSize {
+		bufSize = item.OrigSize
+	}
+	content := io.NewSectionReader(bundle.itemReader(item), 0, item.OrigSize)
+	size, err := io.CopyBuffer(progressWriter{target, progress}, content, make([]byte, bufSize))
+	if err != nil {
+		return size, err
+	}
+	if size != item.OrigSize {
+		return size, io.ErrUnexpectedEOF
+	}
+
+	return size, nil
+}
@@ -1,8 +1,10 @@
 package icepacker
 
 import (
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -50,3 +52,66 @@ func TestUnpacking(t *testing.T) {
 	})
 
 }
+
+func TestParallelUnpacking(t *testing.T) {
+
+	Convey("Should extract the same files with more workers", t, func() {
+
+		source, _ := filepath.Abs("testdata/simple")
+		target, _ := filepath.Abs("testdata/packed/parallel.pack")
+		unTarget, _ := filepath.Abs("testdata/unpacked/parallel")
+
+		result := Pack(PackSettings{
+			SourceDir:      source,
+			TargetFilename: target,
+			Compression:    COMPRESS_GZIP,
+			Encryption:     ENCRYPT_AES,
+			Cipher:         NewCipherSettings("secret"),
+		})
+		So(result.Err, ShouldBeNil)
+
+		listener := &recordListener{}
+		result = Unpack(UnpackSettings{
+			PackFileName: target,
+			TargetDir:    unTarget,
+			Cipher:       NewCipherSettings("secret"),
+			Workers:      4,
+			Listener:     listener,
+		})
+
+		So(result.Err, ShouldBeNil)
+		So(result.FileCount, ShouldEqual, 8)
+		So(listener.errors, ShouldBeEmpty)
+		So(listener.dones, ShouldHaveLength, 8)
+
+		// Every file is reported once and the processed size grows
+		indexes := []int{}
+		doneSize := int64(0)
+		for _, state := range listener.dones {
+			indexes = append(indexes, state.Index)
+			So(state.DoneSize, ShouldBeGreaterThanOrEqualTo, doneSize)
+			doneSize = state.DoneSize
+		}
+		sort.Ints(indexes)
+		So(indexes, ShouldResemble, []int{0, 1, 2, 3, 4, 5, 6, 7})
+		So(doneSize, ShouldEqual, listener.dones[0].TotalSize)
+		So(result.Size, ShouldEqual, doneSize)
+
+		// Check the content of files
+		filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+			if info.IsDir() {
+				return nil
+			}
+			rel, _ := filepath.Rel(source, path)
+			orig, _ := ioutil.ReadFile(path)
+			content, err := ioutil.ReadFile(filepath.Join(unTarget, rel))
+			So(err, ShouldBeNil)
+			So(content, ShouldResemble, orig)
+			return nil
+		})
+
+		os.Remove(target)
+		os.RemoveAll(unTarget)
+	})
+
+}
@@ -0,0 +1,232 @@
+// Package web serves the bundles over HTTP, and opens the bundles from HTTP(S)
+// URLs and S3-compatible object stores. It's separated from the icepacker
+// package, so the programs which only read local bundles (e.g. the stub of the
+// self-extracting executables) don't link the HTTP client & server.
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/icebob/icepacker/lib"
+)
+
+// Handler is an http.Handler which serves the files of the bundle. It sets
+// the Content-Type by extension, the Last-Modified by the MTime of the file
+// and the ETag by the hash of the content (stored in the FAT), and it supports
+// Range requests.
+// The stored GZIP contents of the unencrypted bundles are sent without
+// decompression with `Content-Encoding: gzip`, if the client accepts it.
+type Handler struct {
+	Bundle *icepacker.BundleFile
+
+	// DirIndex enables the index pages (HTML or JSON) of the directories without index.html
+	DirIndex bool
+
+	// Fallback is the path of the file which is served instead of the not
+	// found files (e.g. "index.html" for single-page apps)
+	Fallback string
+}
+
+// NewHandler creates a new Handler of the bundle
+func NewHandler(bundle *icepacker.BundleFile) *Handler {
+	return &Handler{Bundle: bundle}
+}
+
+// ServeHTTP serves the file of the request path. On directories it serves
+// the index.html of the directory, or the index page if DirIndex is set.
+func (this *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	urlPath := r.URL.Path
+	if !strings.HasPrefix(urlPath, "/") {
+		urlPath = "/" + urlPath
+	}
+
+	entry, err := this.Bundle.Stat(urlPath)
+	if err == nil && entry.IsDir {
+		// Redirect to the canonical path of directory, so the relative links of index work
+		if !strings.HasSuffix(urlPath, "/") {
+			redirectToDir(w, r)
+			return
+		}
+		dir := entry.Path
+		entry, err = this.Bundle.Stat(path.Join(urlPath, "index.html"))
+		if err != nil && this.DirIndex {
+			this.serveDirIndex(w, r, dir)
+			return
+		}
+	}
+	if (err != nil || entry.IsDir) && this.Fallback != "" {
+		entry, err = this.Bundle.Stat(this.Fallback)
+	}
+	if err != nil || entry.IsDir {
+		http.NotFound(w, r)
+		return
+	}
+
+	this.ServeItem(w, r, *entry.Item)
+}
+
+// indexEntry is an entry of the JSON directory index
+type indexEntry struct {
+	Name     string     `json:"name"`
+	Path     string     `json:"path"`
+	IsDir    bool       `json:"isDir"`
+	Size     int64      `json:"size"`
+	MTime    *time.Time `json:"mTime,omitempty"`
+	Compress string     `json:"compress,omitempty"`
+}
+
+// dirIndexTemplate is the HTML directory index page
+var dirIndexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Index of {{.Path}}</title>
+<style>body { font-family: sans-serif; } td { padding: 2px 12px; } td.size { text-align: right; }</style>
+</head>
+<body>
+<h1>Index of {{.Path}}</h1>
+<table>
+<tr><th>Name</th><th>Size</th><th>Modified</th></tr>
+{{if ne .Path "/"}}<tr><td><a href="../">../</a></td><td></td><td></td></tr>
+{{end}}{{range .Entries}}<tr>{{if .IsDir}}<td><a href="{{.Name}}/">{{.Name}}/</a></td><td class="size">-</td><td></td>{{else}}<td><a href="{{.Name}}">{{.Name}}</a></td><td class="size">{{.Size}}</td><td>{{.MTime.Format "2006-01-02 15:04:05"}}</td>{{end}}</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// serveDirIndex serves the index page of the directory. It is JSON, if the
+// client accepts it or the `format=json` query is set, otherwise HTML.
+func (this *Handler) serveDirIndex(w http.ResponseWriter, r *http.Request, dir string) {
+	entries, err := this.Bundle.ReadDir(dir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	list := make([]indexEntry, len(entries))
+	for i, entry := range entries {
+		list[i] = indexEntry{Name: entry.Name, Path: entry.Path, IsDir: entry.IsDir}
+		if !entry.IsDir {
+			list[i].Size = entry.Item.OrigSize
+			mtime := time.Unix(0, entry.Item.MTime).UTC()
+			list[i].MTime = &mtime
+			list[i].Compress = icepacker.CompressionName(entry.Item.Compress)
+		}
+	}
+
+	if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+		return
+	}
+
+	dirPath := "/"
+	if dir != "." {
+		dirPath = "/" + dir + "/"
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	dirIndexTemplate.Execute(w, struct {
+		Path    string
+		Entries []indexEntry
+	}{dirPath, list})
+}
+
+// redirectToDir redirects the request to the path with trailing slash
+func redirectToDir(w http.ResponseWriter, r *http.Request) {
+	url := path.Base(r.URL.Path) + "/"
+	if r.URL.RawQuery != "" {
+		url += "?" + r.URL.RawQuery
+	}
+	http.Redirect(w, r, url, http.StatusMovedPermanently)
+}
+
+// ServeItem serves the content of the item with the caching headers
+func (this *Handler) ServeItem(w http.ResponseWriter, r *http.Request, item icepacker.FATItem) {
+	bundle := this.Bundle
+	header := w.Header()
+	modTime := time.Unix(0, item.MTime)
+	contentType := mime.TypeByExtension(path.Ext(item.Path))
+
+	if this.isRawGzip(item) {
+		header.Add("Vary", "Accept-Encoding")
+
+		// The ranges are served from the decoded content
+		if r.Header.Get("Range") == "" && acceptsGzip(r) {
+			blob, err := bundle.ReadRawFile(item)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			if contentType == "" {
+				contentType = "application/octet-stream"
+			}
+			header.Set("Content-Type", contentType)
+			header.Set("Content-Encoding", "gzip")
+			setETag(header, item, "-gzip")
+			http.ServeContent(w, r, item.Path, modTime, bytes.NewReader(blob))
+			return
+		}
+	}
+
+	setETag(header, item, "")
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	http.ServeContent(w, r, item.Path, modTime, io.NewSectionReader(bundle.ItemReaderAt(item), 0, item.OrigSize))
+}
+
+// isRawGzip returns true if the stored blob of the item is a GZIP stream
+// which can be sent to the client without transformation
+func (this *Handler) isRawGzip(item icepacker.FATItem) bool {
+	return item.Compress == icepacker.COMPRESS_GZIP && this.Bundle.Settings.Encryption == icepacker.ENCRYPT_NONE &&
+		!item.Solid && !item.Dict && item.Frames == nil && item.Size > 0
+}
+
+// setETag sets the ETag by the truncated hash of the content and the suffix
+// of the representation. The bundles of the first version don't store the
+// hashes, so their files have no ETag.
+func setETag(header http.Header, item icepacker.FATItem, suffix string) {
+	if item.Hash != [64]byte{} {
+		header.Set("ETag", fmt.Sprintf(`"%x%s"`, item.Hash[:16], suffix))
+	}
+}
+
+// acceptsGzip returns true if the client accepts the GZIP content encoding
+func acceptsGzip(r *http.Request) bool {
+	for _, value := range r.Header["Accept-Encoding"] {
+		for _, part := range strings.Split(value, ",") {
+			fields := strings.Split(part, ";")
+			coding := strings.TrimSpace(fields[0])
+			if coding != "gzip" && coding != "*" {
+				continue
+			}
+
+			// gzip;q=0 means not acceptable
+			accepted := true
+			for _, param := range fields[1:] {
+				param = strings.Replace(param, " ", "", -1)
+				if param == "q=0" || strings.HasPrefix(param, "q=0.") && strings.Trim(param[4:], "0") == "" {
+					accepted = false
+				}
+			}
+			return accepted
+		}
+	}
+	return false
+}
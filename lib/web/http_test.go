@@ -0,0 +1,192 @@
+package web
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha512"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/icebob/icepacker/lib"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHandler(t *testing.T) {
+
+	source, _ := filepath.Abs("../testdata/simple")
+	target, _ := filepath.Abs("../testdata/packed/http.pack")
+	encrypted, _ := filepath.Abs("../testdata/packed/http-aes.pack")
+	defer os.Remove(target)
+	defer os.Remove(encrypted)
+
+	result := icepacker.Pack(icepacker.PackSettings{SourceDir: source, TargetFilename: target, Compression: icepacker.COMPRESS_GZIP})
+	resultAES := icepacker.Pack(icepacker.PackSettings{SourceDir: source, TargetFilename: encrypted, Compression: icepacker.COMPRESS_GZIP, Encryption: icepacker.ENCRYPT_AES, Cipher: icepacker.NewCipherSettings("PackSecretKey")})
+
+	orig, _ := ioutil.ReadFile(filepath.Join(source, "dir1/file3.txt"))
+	origInfo, _ := os.Stat(filepath.Join(source, "dir1/file3.txt"))
+
+	request := func(handler http.Handler, method string, url string, headers map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, url, nil)
+		for name, value := range headers {
+			r.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	Convey("Should serve the files of the bundle", t, func() {
+		So(result.Err, ShouldBeNil)
+
+		bundle, err := icepacker.OpenBundle(target, nil)
+		So(err, ShouldBeNil)
+		defer bundle.Close()
+		handler := NewHandler(bundle)
+
+		{
+			// Should serve the decoded content with the caching headers
+			w := request(handler, "GET", "/dir1/file3.txt", nil)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Body.Bytes(), ShouldResemble, orig)
+			So(w.Header().Get("Content-Type"), ShouldEqual, "text/plain; charset=utf-8")
+			So(w.Header().Get("Content-Encoding"), ShouldEqual, "")
+			So(w.Header().Get("Vary"), ShouldEqual, "Accept-Encoding")
+			So(w.Header().Get("Last-Modified"), ShouldEqual, origInfo.ModTime().UTC().Format(http.TimeFormat))
+			hash := sha512.Sum512(orig)
+			So(w.Header().Get("ETag"), ShouldEqual, fmt.Sprintf(`"%x"`, hash[:16]))
+
+			etag := w.Header().Get("ETag")
+			w = request(handler, "GET", "/dir1/file3.txt", map[string]string{"If-None-Match": etag})
+			So(w.Code, ShouldEqual, http.StatusNotModified)
+		}
+
+		{
+			// Should send the stored GZIP content
+			w := request(handler, "GET", "/dir1/file3.txt", map[string]string{"Accept-Encoding": "br, gzip"})
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Header().Get("Content-Encoding"), ShouldEqual, "gzip")
+			So(w.Header().Get("Content-Type"), ShouldEqual, "text/plain; charset=utf-8")
+
+			item, _ := bundle.GetItemByPath("dir1/file3.txt")
+			So(w.Body.Len(), ShouldEqual, item.Size)
+
+			gz, err := gzip.NewReader(bytes.NewReader(w.Body.Bytes()))
+			So(err, ShouldBeNil)
+			content, err := ioutil.ReadAll(gz)
+			So(err, ShouldBeNil)
+			So(content, ShouldResemble, orig)
+
+			// Other representation, other ETag
+			plain := request(handler, "GET", "/dir1/file3.txt", nil)
+			So(w.Header().Get("ETag"), ShouldNotEqual, plain.Header().Get("ETag"))
+
+			w = request(handler, "GET", "/dir1/file3.txt", map[string]string{"Accept-Encoding": "gzip;q=0"})
+			So(w.Header().Get("Content-Encoding"), ShouldEqual, "")
+		}
+
+		{
+			// Should serve the ranges from the decoded content
+			w := request(handler, "GET", "/dir1/file3.txt", map[string]string{"Range": "bytes=6-10", "Accept-Encoding": "gzip"})
+			So(w.Code, ShouldEqual, http.StatusPartialContent)
+			So(w.Header().Get("Content-Encoding"), ShouldEqual, "")
+			So(w.Body.String(), ShouldEqual, string(orig[6:11]))
+			So(w.Header().Get("Content-Range"), ShouldEqual, "bytes 6-10/2382")
+		}
+
+		{
+			// Should serve the index of directories
+			w := request(handler, "GET", "/dir2/", nil)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Header().Get("Content-Type"), ShouldEqual, "text/html; charset=utf-8")
+
+			w = request(handler, "GET", "/dir2", nil)
+			So(w.Code, ShouldEqual, http.StatusMovedPermanently)
+			So(w.Header().Get("Location"), ShouldEqual, "/dir2/")
+
+			w = request(handler, "GET", "/dir1/", nil)
+			So(w.Code, ShouldEqual, http.StatusNotFound)
+		}
+
+		{
+			// Should give errors
+			So(request(handler, "GET", "/not-exists.txt", nil).Code, ShouldEqual, http.StatusNotFound)
+			So(request(handler, "POST", "/file1.txt", nil).Code, ShouldEqual, http.StatusMethodNotAllowed)
+
+			w := request(handler, "HEAD", "/file1.txt", nil)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Body.Len(), ShouldEqual, 0)
+		}
+	})
+
+	Convey("Should serve the directory index pages & the fallback", t, func() {
+		bundle, err := icepacker.OpenBundleLazy(target, nil)
+		So(err, ShouldBeNil)
+		defer bundle.Close()
+
+		handler := NewHandler(bundle)
+		handler.DirIndex = true
+		handler.Fallback = "dir2/index.html"
+
+		{
+			// Should serve the HTML index
+			w := request(handler, "GET", "/dir1/", nil)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Header().Get("Content-Type"), ShouldEqual, "text/html; charset=utf-8")
+			So(w.Body.String(), ShouldContainSubstring, "<title>Index of /dir1/</title>")
+			So(w.Body.String(), ShouldContainSubstring, `<a href="file3.txt">file3.txt</a></td><td class="size">2382</td>`)
+			So(w.Body.String(), ShouldContainSubstring, `<a href="../">`)
+
+			w = request(handler, "GET", "/", nil)
+			So(w.Body.String(), ShouldContainSubstring, `<a href="dir1/">dir1/</a>`)
+			So(w.Body.String(), ShouldNotContainSubstring, `<a href="../">`)
+		}
+
+		{
+			// Should serve the JSON index
+			w := request(handler, "GET", "/dir1/?format=json", nil)
+			So(w.Header().Get("Content-Type"), ShouldEqual, "application/json")
+
+			var entries []indexEntry
+			So(json.Unmarshal(w.Body.Bytes(), &entries), ShouldBeNil)
+			So(len(entries), ShouldEqual, 2)
+			So(entries[0].Path, ShouldEqual, "dir1/file3.txt")
+			So(entries[0].Size, ShouldEqual, 2382)
+			So(entries[0].Compress, ShouldEqual, "gzip")
+
+			w = request(handler, "GET", "/", map[string]string{"Accept": "application/json"})
+			So(json.Unmarshal(w.Body.Bytes(), &entries), ShouldBeNil)
+			So(entries[1].Name, ShouldEqual, "dir1")
+			So(entries[1].IsDir, ShouldBeTrue)
+		}
+
+		{
+			// Should serve the fallback instead of the not found files
+			w := request(handler, "GET", "/app/route/1", nil)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Header().Get("Content-Type"), ShouldEqual, "text/html; charset=utf-8")
+
+			index, _ := ioutil.ReadFile(filepath.Join(source, "dir2/index.html"))
+			So(w.Body.Bytes(), ShouldResemble, index)
+		}
+	})
+
+	Convey("Should decode the files of the encrypted bundle", t, func() {
+		So(resultAES.Err, ShouldBeNil)
+
+		bundle, err := icepacker.OpenBundle(encrypted, icepacker.HashingKey(icepacker.NewCipherSettings("PackSecretKey")))
+		So(err, ShouldBeNil)
+		defer bundle.Close()
+
+		w := request(NewHandler(bundle), "GET", "/dir1/file3.txt", map[string]string{"Accept-Encoding": "gzip"})
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(w.Header().Get("Content-Encoding"), ShouldEqual, "")
+		So(w.Header().Get("Vary"), ShouldEqual, "")
+		So(w.Body.Bytes(), ShouldResemble, orig)
+	})
+}
@@ -0,0 +1,132 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/icebob/icepacker/lib"
+)
+
+// IsURL returns true if the name of the bundle is an HTTP(S) URL
+func IsURL(name string) bool {
+	return strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://")
+}
+
+// OpenBundleURL opens a bundle file from an HTTP(S) server. It loads only the
+// footer, the header and the FAT with Range requests, and the contents of
+// files are downloaded on reading. The server must support Range requests.
+// The bundle can't be modified.
+func OpenBundleURL(url string, cipherKey []byte) (*icepacker.BundleFile, error) {
+	reader := &httpReaderAt{client: http.DefaultClient, url: url}
+
+	size, err := reader.fetchSize()
+	if err != nil {
+		return nil, err
+	}
+	reader.size = size
+
+	bundle, err := icepacker.OpenBundleReader(reader, size, cipherKey)
+	if err != nil {
+		return nil, err
+	}
+	bundle.Path = url
+	return bundle, nil
+}
+
+func init() {
+	// OpenPack (so Unpack & ListPack) opens the URLs with OpenBundleURL
+	icepacker.RegisterOpener("http://", OpenBundleURL)
+	icepacker.RegisterOpener("https://", OpenBundleURL)
+}
+
+// httpReaderAt is an io.ReaderAt of a remote file with Range requests
+type httpReaderAt struct {
+	client *http.Client
+	url    string
+	size   int64
+
+	// sign prepares the request before sending (e.g. signs the S3 requests)
+	sign func(req *http.Request)
+}
+
+// get sends a GET request of the range and checks the partial response
+func (this *httpReaderAt) get(byteRange string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", this.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", "bytes="+byteRange)
+	if this.sign != nil {
+		this.sign(req)
+	}
+
+	res, err := this.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusPartialContent {
+		res.Body.Close()
+		if res.StatusCode == http.StatusOK {
+			return nil, errors.New("The server doesn't support Range requests! URL: " + this.url)
+		}
+		return nil, fmt.Errorf("Invalid response status: %s URL: %s", res.Status, this.url)
+	}
+	return res, nil
+}
+
+// fetchSize returns the size of the remote file from the Content-Range of
+// a one byte response
+func (this *httpReaderAt) fetchSize() (int64, error) {
+	res, err := this.get("0-0")
+	if err != nil {
+		return 0, err
+	}
+	res.Body.Close()
+
+	// Content-Range: bytes 0-0/12345
+	contentRange := res.Header.Get("Content-Range")
+	i := strings.LastIndex(contentRange, "/")
+	if i < 0 {
+		return 0, errors.New("Invalid Content-Range: " + contentRange)
+	}
+	size, err := strconv.ParseInt(contentRange[i+1:], 10, 64)
+	if err != nil {
+		return 0, errors.New("Invalid Content-Range: " + contentRange)
+	}
+	return size, nil
+}
+
+// ReadAt reads len(p) bytes from the remote file at offset `off` with a Range request
+func (this *httpReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("Negative offset!")
+	}
+	if off >= this.size {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	size := int64(len(p))
+	if off+size > this.size {
+		size = this.size - off
+	}
+
+	res, err := this.get(fmt.Sprintf("%d-%d", off, off+size-1))
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	n, err := io.ReadFull(res.Body, p[:size])
+	if err == nil && size < int64(len(p)) {
+		err = io.EOF
+	}
+	return n, err
+}
@@ -0,0 +1,127 @@
+package web
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/icebob/icepacker/lib"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// countingHandler counts the requests & the sent bytes of the handler
+type countingHandler struct {
+	handler  http.Handler
+	lock     sync.Mutex
+	requests int
+	sent     int64
+}
+
+type countingWriter struct {
+	http.ResponseWriter
+	counter *countingHandler
+}
+
+func (this countingWriter) Write(p []byte) (int, error) {
+	this.counter.lock.Lock()
+	this.counter.sent += int64(len(p))
+	this.counter.lock.Unlock()
+	return this.ResponseWriter.Write(p)
+}
+
+func (this *countingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	this.lock.Lock()
+	this.requests++
+	this.lock.Unlock()
+	this.handler.ServeHTTP(countingWriter{w, this}, r)
+}
+
+func TestRemoteBundle(t *testing.T) {
+
+	source, _ := filepath.Abs("../testdata/remote")
+	target, _ := filepath.Abs("../testdata/packed/remote.pack")
+	unTarget, _ := filepath.Abs("../testdata/unpacked/remote")
+	defer os.RemoveAll(source)
+	defer os.Remove(target)
+	defer os.RemoveAll(unTarget)
+
+	// A small file and a large file
+	os.MkdirAll(filepath.Join(source, "data"), icepacker.DEFAULT_PERMISSION)
+	ioutil.WriteFile(filepath.Join(source, "readme.txt"), []byte("Readme of the remote bundle"), 0644)
+	large := make([]byte, 3<<20)
+	for i := range large {
+		large[i] = byte(i * 7 % 251)
+	}
+	ioutil.WriteFile(filepath.Join(source, "data/large.bin"), large, 0644)
+
+	cipher := icepacker.NewCipherSettings("PackSecretKey")
+	result := icepacker.Pack(icepacker.PackSettings{SourceDir: source, TargetFilename: target, Compression: icepacker.COMPRESS_GZIP, Encryption: icepacker.ENCRYPT_AES, Cipher: cipher})
+	stat, _ := os.Stat(target)
+
+	counter := &countingHandler{handler: http.FileServer(http.Dir(filepath.Dir(target)))}
+	server := httptest.NewServer(counter)
+	defer server.Close()
+	url := server.URL + "/remote.pack"
+
+	Convey("Should read the files of the remote bundle with Range requests", t, func() {
+		So(result.Err, ShouldBeNil)
+
+		bundle, err := OpenBundleURL(url, icepacker.HashingKey(cipher))
+		So(err, ShouldBeNil)
+		defer bundle.Close()
+
+		So(len(bundle.FAT.Items), ShouldEqual, 2)
+		So(counter.sent, ShouldBeLessThan, 4096)
+
+		content, err := bundle.ReadFileFromPath("readme.txt")
+		So(err, ShouldBeNil)
+		So(string(content), ShouldEqual, "Readme of the remote bundle")
+		So(counter.sent, ShouldBeLessThan, 4096)
+
+		// Should download only the needed frame
+		reader, err := bundle.OpenReaderAt("data/large.bin")
+		So(err, ShouldBeNil)
+		buf := make([]byte, 100)
+		_, err = reader.ReadAt(buf, 2<<20+10)
+		So(err, ShouldBeNil)
+		So(buf, ShouldResemble, large[2<<20+10:2<<20+110])
+		So(counter.sent, ShouldBeLessThan, stat.Size()/2)
+
+		content, err = bundle.ReadFileFromPath("data/large.bin")
+		So(err, ShouldBeNil)
+		So(content, ShouldResemble, large)
+
+		_, err = bundle.AddFile("new.txt", filepath.Join(source, "readme.txt"))
+		So(err.Error(), ShouldEqual, "The bundle is opened read-only!")
+	})
+
+	Convey("Should list & unpack the remote bundle", t, func() {
+		list := icepacker.ListPack(icepacker.ListSettings{PackFileName: url, Cipher: cipher})
+		So(list.Err, ShouldBeNil)
+		So(list.FAT.Count, ShouldEqual, 2)
+
+		res := icepacker.Unpack(icepacker.UnpackSettings{PackFileName: url, TargetDir: unTarget, Cipher: cipher, Workers: 2})
+		So(res.Err, ShouldBeNil)
+		content, err := ioutil.ReadFile(filepath.Join(unTarget, "data/large.bin"))
+		So(err, ShouldBeNil)
+		So(content, ShouldResemble, large)
+	})
+
+	Convey("Should give error if the server doesn't support Range requests", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.ServeFile(w, &http.Request{Method: r.Method, URL: r.URL, Header: http.Header{}}, target)
+		}))
+		defer server.Close()
+
+		_, err := OpenBundleURL(server.URL+"/remote.pack", nil)
+		So(err.Error(), ShouldStartWith, "The server doesn't support Range requests!")
+
+		_, err = OpenBundleURL(strings.Replace(url, "remote.pack", "not-exists.pack", 1), nil)
+		So(err.Error(), ShouldStartWith, "Invalid response status: 404")
+	})
+}
@@ -0,0 +1,415 @@
+package web
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/icebob/icepacker/lib"
+)
+
+// S3_PART_SIZE is the default size of the parts of the multipart uploads
+const S3_PART_SIZE = 8 * 1024 * 1024
+
+// S3Storage stores the bundle files in a bucket of an S3-compatible object
+// store. The bundles are uploaded with multipart upload and read with ranged
+// GET requests, so they are never stored locally.
+type S3Storage struct {
+	// Endpoint is the URL of the service (e.g. "https://s3.eu-west-1.amazonaws.com")
+	Endpoint string
+	Region   string
+	Bucket   string
+
+	// The credentials of the requests. The requests aren't signed, if the AccessKey is empty.
+	AccessKey string
+	SecretKey string
+
+	// Client sends the requests (http.DefaultClient if nil)
+	Client *http.Client
+
+	// PartSize is the size of the uploaded parts (S3_PART_SIZE if 0). The
+	// header of the bundle is rewritten on finalize, so the first part is
+	// kept in memory until the upload is completed.
+	PartSize int64
+}
+
+// client returns the HTTP client of the storage
+func (this *S3Storage) client() *http.Client {
+	if this.Client != nil {
+		return this.Client
+	}
+	return http.DefaultClient
+}
+
+// objectURL returns the path-style URL of the object
+func (this *S3Storage) objectURL(name string) string {
+	parts := strings.Split(strings.TrimPrefix(name, "/"), "/")
+	for i, part := range parts {
+		parts[i] = uriEncode(part)
+	}
+	return strings.TrimSuffix(this.Endpoint, "/") + "/" + uriEncode(this.Bucket) + "/" + strings.Join(parts, "/")
+}
+
+// do signs & sends the request with the body
+func (this *S3Storage) do(method string, rawurl string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, rawurl, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	this.sign(req, body, time.Now())
+	return this.client().Do(req)
+}
+
+// Open opens the object of the bucket for ranged reads
+func (this *S3Storage) Open(name string) (icepacker.StorageReader, error) {
+	rawurl := this.objectURL(name)
+	req, err := http.NewRequest("HEAD", rawurl, nil)
+	if err != nil {
+		return nil, err
+	}
+	this.sign(req, nil, time.Now())
+
+	res, err := this.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Invalid response status: %s URL: %s", res.Status, rawurl)
+	}
+
+	size, err := strconv.ParseInt(res.Header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return nil, errors.New("Invalid Content-Length! URL: " + rawurl)
+	}
+
+	reader := &httpReaderAt{client: this.client(), url: rawurl, size: size}
+	reader.sign = func(req *http.Request) { this.sign(req, nil, time.Now()) }
+	return &s3Reader{reader}, nil
+}
+
+// Create creates the object in the bucket. The object is created by Commit.
+func (this *S3Storage) Create(name string) (icepacker.StorageWriter, error) {
+	partSize := this.PartSize
+	if partSize <= 0 {
+		partSize = S3_PART_SIZE
+	}
+	return &s3Writer{storage: this, url: this.objectURL(name), partSize: partSize}, nil
+}
+
+// s3Reader is the StorageReader of S3Storage
+type s3Reader struct {
+	*httpReaderAt
+}
+
+func (this *s3Reader) Size() int64  { return this.size }
+func (this *s3Reader) Close() error { return nil }
+
+// s3Part is an uploaded part of the multipart upload
+type s3Part struct {
+	PartNumber int
+	ETag       string
+}
+
+// s3Writer is the StorageWriter of S3Storage. The content is buffered in
+// parts, the multipart upload is initiated when the second part is full.
+type s3Writer struct {
+	storage  *S3Storage
+	url      string
+	partSize int64
+
+	// first is the first part, which can be rewritten until the commit
+	first []byte
+
+	// part is the current part after the first
+	part []byte
+
+	size     int64
+	uploadID string
+	parts    []s3Part
+	done     bool
+}
+
+// WriteAt appends the content, or rewrites the first part
+func (this *s3Writer) WriteAt(p []byte, off int64) (int, error) {
+	if this.done {
+		return 0, errors.New("The upload is closed!")
+	}
+
+	if off != this.size {
+		if off < 0 || off+int64(len(p)) > int64(len(this.first)) {
+			return 0, fmt.Errorf("Invalid write offset! Offset: %d", off)
+		}
+		copy(this.first[off:], p)
+		return len(p), nil
+	}
+
+	n := len(p)
+	for len(p) > 0 {
+		if int64(len(this.first)) < this.partSize {
+			size := min64(this.partSize-int64(len(this.first)), int64(len(p)))
+			this.first = append(this.first, p[:size]...)
+			p = p[size:]
+			continue
+		}
+
+		size := min64(this.partSize-int64(len(this.part)), int64(len(p)))
+		this.part = append(this.part, p[:size]...)
+		p = p[size:]
+		if int64(len(this.part)) == this.partSize {
+			if err := this.uploadPart(len(this.parts)+2, this.part); err != nil {
+				return 0, err
+			}
+			this.part = nil
+		}
+	}
+	this.size += int64(n)
+	return n, nil
+}
+
+// uploadPart uploads a part, and initiates the multipart upload on the first call
+func (this *s3Writer) uploadPart(number int, content []byte) error {
+	if this.uploadID == "" {
+		res, err := this.storage.do("POST", this.url+"?uploads", nil)
+		if err != nil {
+			return err
+		}
+		var result struct {
+			UploadID string `xml:"UploadId"`
+		}
+		if err := readS3Response(res, &result); err != nil {
+			return err
+		}
+		if result.UploadID == "" {
+			return errors.New("Missing upload ID! URL: " + this.url)
+		}
+		this.uploadID = result.UploadID
+	}
+
+	res, err := this.storage.do("PUT", fmt.Sprintf("%s?partNumber=%d&uploadId=%s", this.url, number, url.QueryEscape(this.uploadID)), content)
+	if err != nil {
+		return err
+	}
+	if err := readS3Response(res, nil); err != nil {
+		return err
+	}
+	this.parts = append(this.parts, s3Part{PartNumber: number, ETag: res.Header.Get("ETag")})
+	return nil
+}
+
+// Commit uploads the buffered parts and completes the upload
+func (this *s3Writer) Commit() error {
+	if this.done {
+		return errors.New("The upload is closed!")
+	}
+
+	// The small files are uploaded in one request
+	if this.uploadID == "" {
+		res, err := this.storage.do("PUT", this.url, append(this.first, this.part...))
+		if err != nil {
+			return err
+		}
+		if err := readS3Response(res, nil); err != nil {
+			return err
+		}
+		this.done = true
+		return nil
+	}
+
+	if len(this.part) > 0 {
+		if err := this.uploadPart(len(this.parts)+2, this.part); err != nil {
+			return err
+		}
+	}
+	if err := this.uploadPart(1, this.first); err != nil {
+		return err
+	}
+
+	sort.Slice(this.parts, func(i, j int) bool { return this.parts[i].PartNumber < this.parts[j].PartNumber })
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []s3Part `xml:"Part"`
+	}{Parts: this.parts})
+	if err != nil {
+		return err
+	}
+
+	res, err := this.storage.do("POST", this.url+"?uploadId="+url.QueryEscape(this.uploadID), body)
+	if err != nil {
+		return err
+	}
+	if err := readS3Response(res, nil); err != nil {
+		return err
+	}
+	this.done = true
+	this.first, this.part = nil, nil
+	return nil
+}
+
+// Abort drops the buffered parts and aborts the multipart upload
+func (this *s3Writer) Abort() error {
+	if this.done {
+		return nil
+	}
+	this.done = true
+	this.first, this.part = nil, nil
+	if this.uploadID == "" {
+		return nil
+	}
+
+	res, err := this.storage.do("DELETE", this.url+"?uploadId="+url.QueryEscape(this.uploadID), nil)
+	if err != nil {
+		return err
+	}
+	return readS3Response(res, nil)
+}
+
+// s3Error is the error response of S3
+type s3Error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string
+	Message string
+}
+
+// readS3Response reads & closes the response. It returns the S3 error of
+// the response, or decodes the XML result into `result` (if not nil).
+func readS3Response(res *http.Response, result interface{}) error {
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	// The error of the completion can be sent with 200 OK
+	var s3err s3Error
+	if xml.Unmarshal(body, &s3err) == nil && s3err.Code != "" {
+		return fmt.Errorf("S3 error: %s %s URL: %s", s3err.Code, s3err.Message, res.Request.URL)
+	}
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("Invalid response status: %s URL: %s", res.Status, res.Request.URL)
+	}
+
+	if result != nil {
+		if err := xml.Unmarshal(body, result); err != nil {
+			return fmt.Errorf("Invalid response! URL: %s", res.Request.URL)
+		}
+	}
+	return nil
+}
+
+// sign signs the request with the AWS Signature Version 4
+func (this *S3Storage) sign(req *http.Request, body []byte, now time.Time) {
+	if this.AccessKey == "" {
+		return
+	}
+
+	hash := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(hash[:])
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "range" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + this.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+this.SecretKey), date)
+	key = hmacSHA256(key, this.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+this.AccessKey+"/"+scope+
+		",SignedHeaders="+signedHeaders+",Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery returns the query sorted by name with URI encoding
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := []string{}
+	for _, name := range names {
+		values := query[name]
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, uriEncode(name)+"="+uriEncode(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode encodes the string by the rules of AWS (only the unreserved characters are kept)
+func uriEncode(s string) string {
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~", c) >= 0 {
+			buf.WriteByte(c)
+		} else {
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
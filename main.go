@@ -2,13 +2,18 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"time"
 
 	"github.com/urfave/cli"
 
 	"github.com/icebob/icepacker/lib"
+	"github.com/icebob/icepacker/lib/web"
 )
 
 // GitCommit contains the commit hash. This will be filled in by the compiler.
@@ -46,7 +51,44 @@ func main() {
 				cli.StringFlag{
 					Name:  "compress, c",
 					Value: "none",
-					Usage: "Type of compression (none, gzip)",
+					Usage: "Type of compression (none, gzip, snappy, zstd, deflate)",
+				},
+
+				cli.IntFlag{
+					Name:  "level, l",
+					Value: icepacker.LEVEL_DEFAULT,
+					Usage: "Level of compression (gzip: 1-9, zstd: 1-22, 0: best compression)",
+				},
+
+				cli.IntFlag{
+					Name:  "long",
+					Usage: "Long-range window of zstd compression as a power of 2 (e.g. 27: 128 MB)",
+				},
+
+				cli.StringSliceFlag{
+					Name:  "rule, r",
+					Usage: "Compression rule of files as `PATTERN=TYPE` (e.g. \"*.mp3=none\")",
+				},
+
+				cli.BoolFlag{
+					Name:  "solid",
+					Usage: "Compress the small files together in solid segments (better ratio, slower random access)",
+				},
+
+				cli.BoolFlag{
+					Name:  "dict",
+					Usage: "Compress the small files with a shared dictionary (only with deflate compression, also in rules)",
+				},
+
+				cli.BoolFlag{
+					Name:  "skip-compressed",
+					Usage: "Store the commonly compressed files (zip, png, mp3...etc) without compression",
+				},
+
+				cli.IntFlag{
+					Name:  "jobs, j",
+					Value: runtime.NumCPU(),
+					Usage: "Count of files processed concurrently",
 				},
 			},
 			Action: pack,
@@ -60,6 +102,12 @@ func main() {
 					Value: "",
 					Usage: "Key for decrypting if the file is encrypted",
 				},
+
+				cli.IntFlag{
+					Name:  "jobs, j",
+					Value: runtime.NumCPU(),
+					Usage: "Count of files extracted concurrently",
+				},
 			},
 			Action: unpack,
 		},
@@ -75,6 +123,82 @@ func main() {
 			},
 			Action: list,
 		},
+		{
+			Name:  "cat",
+			Usage: "Print the content of a file from a `PACK FILE` to STDOUT",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "key, k",
+					Value: "",
+					Usage: "Key for decrypting if the file is encrypted",
+				},
+			},
+			Action: cat,
+		},
+		{
+			Name:  "serve",
+			Usage: "Serve files of a `PACK FILE` over HTTP",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "addr, a",
+					Value: ":8080",
+					Usage: "Address to listen on",
+				},
+
+				cli.StringFlag{
+					Name:  "key, k",
+					Value: "",
+					Usage: "Key for decrypting if the file is encrypted",
+				},
+
+				cli.BoolFlag{
+					Name:  "spa",
+					Usage: "Serve the index.html instead of the not found files (single-page app)",
+				},
+
+				cli.BoolFlag{
+					Name:  "no-index",
+					Usage: "Disable the index pages of directories",
+				},
+
+				cli.StringFlag{
+					Name:  "tls-cert",
+					Usage: "Certificate file for HTTPS",
+				},
+
+				cli.StringFlag{
+					Name:  "tls-key",
+					Usage: "Private key file of the certificate for HTTPS",
+				},
+
+				cli.IntFlag{
+					Name:  "cache",
+					Value: 64,
+					Usage: "Size of the cache of decoded files in MB (0: disabled)",
+				},
+			},
+			Action: serve,
+		},
+		{
+			Name:  "sfx",
+			Usage: "Create a self-extracting executable from a `PACK FILE` to `OUTPUT FILE`",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "stub, s",
+					Value: runtime.GOOS + "-" + runtime.GOARCH,
+					Usage: "Platform of the stub (GOOS-GOARCH) or the path of a stub file",
+				},
+				cli.StringFlag{
+					Name:  "dir, d",
+					Usage: "Default target directory of the extraction (default: a temporary directory)",
+				},
+				cli.StringFlag{
+					Name:  "run, r",
+					Usage: "Entry command which is run in the target directory after the extraction",
+				},
+			},
+			Action: sfx,
+		},
 	}
 
 	app.Run(os.Args)
@@ -93,58 +217,67 @@ func pack(c *cli.Context) error {
 		fmt.Println("Encryption: ", "AES128")
 	}
 
-	compression := 0
-	switch c.String("compress") {
-	case "gz", "gzip":
-		compression = icepacker.COMPRESS_GZIP
-		fmt.Println("Compression: ", "GZIP")
+	compression, err := parseCompression(c.String("compress"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if compression != icepacker.COMPRESS_NONE {
+		fmt.Println("Compression: ", strings.ToUpper(icepacker.CompressionName(compression)))
+	}
+
+	rules := []icepacker.CompressRule{}
+	for _, rule := range c.StringSlice("rule") {
+		parts := strings.SplitN(rule, "=", 2)
+		if len(parts) != 2 {
+			return cli.NewExitError(fmt.Sprintf("Invalid compression rule: %s", rule), 1)
+		}
+		ruleCompression, err := parseCompression(parts[1])
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		rules = append(rules, icepacker.CompressRule{Pattern: parts[0], Compression: ruleCompression})
+	}
+	if c.Bool("skip-compressed") {
+		rules = append(rules, icepacker.CompressedFileRules...)
+	}
+
+	windowSize := 0
+	if long := c.Int("long"); long > 0 {
+		if long > 31 {
+			return cli.NewExitError(fmt.Sprintf("Invalid long-range window: %d", long), 1)
+		}
+		windowSize = 1 << uint(long)
 	}
 
 	if encryption > 0 && c.String("key") == "" {
 		return cli.NewExitError("Please set the encryption key with --key parameter", 1)
 	}
 
-	chanProgress := make(chan icepacker.ProgressState, 10)
-	chanFinish := make(chan icepacker.FinishResult)
-
 	start := time.Now()
-	go icepacker.Pack(icepacker.PackSettings{
+	res := icepacker.Pack(icepacker.PackSettings{
 		SourceDir:      c.Args()[0],
 		TargetFilename: c.Args()[1],
-		Compression:    byte(compression),
+		Compression:    compression,
+		Level:          c.Int("level"),
+		Rules:          rules,
+		Solid:          c.Bool("solid"),
+		WindowSize:     windowSize,
+		Dictionary:     c.Bool("dict"),
 		Encryption:     byte(encryption),
 		Cipher:         icepacker.NewCipherSettings(c.String("key")),
-		OnProgress:     chanProgress,
-		OnFinish:       chanFinish,
+		Workers:        c.Int("jobs"),
+		Listener:       NewProgress("Packing files"),
 	})
 
-	done := false
-	for {
-		select {
-		case state := <-chanProgress:
-			if state.Err != nil {
-				fmt.Printf("ERROR: %s (file: %s)\n", state.Err, state.CurrentFile)
-			} else {
-				PrintProgress("Packing files", state.Index, state.Total)
-			}
-		case res := <-chanFinish:
-			if res.Err != nil {
-				return cli.NewExitError(fmt.Sprintf("%s", res.Err), 3)
-			}
-
-			elapsed := time.Since(start)
-			fmt.Printf("\nPack size: %s\n", FormatBytes(res.Size))
-			fmt.Printf("File count: %d, skipped duplicate: %d (%s)\n", res.FileCount, res.DupCount, FormatBytes(res.DupSize))
-			fmt.Printf("Elapsed time: %s\n", elapsed)
-
-			done = true
-		}
-
-		if done {
-			break
-		}
+	if res.Err != nil {
+		return cli.NewExitError(fmt.Sprintf("%s", res.Err), 3)
 	}
 
+	elapsed := time.Since(start)
+	fmt.Printf("Pack size: %s\n", FormatBytes(res.Size))
+	fmt.Printf("File count: %d, skipped duplicate: %d (%s)\n", res.FileCount, res.DupCount, FormatBytes(res.DupSize))
+	fmt.Printf("Elapsed time: %s\n", elapsed)
+
 	return nil
 }
 
@@ -165,48 +298,44 @@ func unpack(c *cli.Context) error {
 		return cli.NewExitError("Please set package filename and target directory", 2)
 	}
 
-	chanProgress := make(chan icepacker.ProgressState, 10)
-	chanFinish := make(chan icepacker.FinishResult)
-
 	start := time.Now()
-	go icepacker.Unpack(icepacker.UnpackSettings{
+	res := icepacker.Unpack(icepacker.UnpackSettings{
 		PackFileName: bundleFile,
 		TargetDir:    targetDir,
 		Cipher:       icepacker.NewCipherSettings(c.String("key")),
-		OnProgress:   chanProgress,
-		OnFinish:     chanFinish,
+		Workers:      c.Int("jobs"),
+		Listener:     NewProgress("Unpacking files"),
 	})
 
-	done := false
-	for {
-		select {
-		case state := <-chanProgress:
-			if state.Err != nil {
-				fmt.Printf("ERROR: %s (file: %s)\n", state.Err, state.CurrentFile)
-			} else {
-				PrintProgress("Unpacking files", state.Index, state.Total)
-			}
-		case res := <-chanFinish:
-			if res.Err != nil {
-				return cli.NewExitError(fmt.Sprintf("%s", res.Err), 3)
-			}
-
-			elapsed := time.Since(start)
-			fmt.Printf("\nTotal size: %s\n", FormatBytes(res.Size))
-			fmt.Printf("File count: %d\n", res.FileCount)
-			fmt.Printf("Elapsed time: %s\n", elapsed)
-
-			done = true
-		}
-
-		if done {
-			break
-		}
+	if res.Err != nil {
+		return cli.NewExitError(fmt.Sprintf("%s", res.Err), 3)
 	}
 
+	elapsed := time.Since(start)
+	fmt.Printf("Total size: %s\n", FormatBytes(res.Size))
+	fmt.Printf("File count: %d\n", res.FileCount)
+	fmt.Printf("Elapsed time: %s\n", elapsed)
+
 	return nil
 }
 
+// parseCompression parses the type of compression from the name
+func parseCompression(name string) (byte, error) {
+	switch name {
+	case "", "none":
+		return icepacker.COMPRESS_NONE, nil
+	case "gz", "gzip":
+		return icepacker.COMPRESS_GZIP, nil
+	case "snappy":
+		return icepacker.COMPRESS_SNAPPY, nil
+	case "zst", "zstd":
+		return icepacker.COMPRESS_ZSTD, nil
+	case "deflate":
+		return icepacker.COMPRESS_DEFLATE, nil
+	}
+	return 0, fmt.Errorf("Unknown compression type: %s", name)
+}
+
 func list(c *cli.Context) error {
 	bundleFile := "-" // read from STDIN
 
@@ -220,23 +349,18 @@ func list(c *cli.Context) error {
 		return cli.NewExitError("Please set package filename", 2)
 	}
 
-	chanFinish := make(chan icepacker.ListResult)
-
-	go icepacker.ListPack(icepacker.ListSettings{
+	res := icepacker.ListPack(icepacker.ListSettings{
 		PackFileName: bundleFile,
 		Cipher:       icepacker.NewCipherSettings(c.String("key")),
-		OnFinish:     chanFinish,
 	})
 
-	res := <-chanFinish
-
 	if res.Err != nil {
 		return cli.NewExitError(fmt.Sprintf("%s", res.Err), 3)
 	}
 
 	fmt.Println("Files in package:")
 	for _, item := range res.FAT.Items {
-		fmt.Printf("  %s (%s)\n", filepath.FromSlash(item.Path), FormatBytes(item.OrigSize))
+		fmt.Printf("  %s (%s, %s)\n", filepath.FromSlash(item.Path), FormatBytes(item.OrigSize), icepacker.CompressionName(item.Compress))
 	}
 
 	fmt.Printf("\nFile count: %d\n", res.FAT.Count)
@@ -244,3 +368,69 @@ func list(c *cli.Context) error {
 
 	return nil
 }
+
+func cat(c *cli.Context) error {
+	if len(c.Args()) != 2 {
+		cli.ShowCommandHelp(c, "cat")
+		return cli.NewExitError("Please set package filename and the path of file", 2)
+	}
+
+	bundle, err := icepacker.OpenPack(c.Args()[0], icepacker.HashingKey(icepacker.NewCipherSettings(c.String("key"))))
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("%s", err), 3)
+	}
+	defer bundle.Close()
+
+	item, err := bundle.GetItemByPath(filepath.ToSlash(c.Args()[1]))
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("%s", err), 3)
+	}
+
+	// Copy the content frame by frame
+	reader, err := bundle.OpenReaderAt(item.Path)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("%s", err), 3)
+	}
+	if _, err := io.Copy(os.Stdout, io.NewSectionReader(reader, 0, item.OrigSize)); err != nil {
+		return cli.NewExitError(fmt.Sprintf("%s", err), 3)
+	}
+
+	return nil
+}
+
+func serve(c *cli.Context) error {
+	if len(c.Args()) != 1 {
+		cli.ShowCommandHelp(c, "serve")
+		return cli.NewExitError("Please set package filename", 2)
+	}
+
+	certFile, keyFile := c.String("tls-cert"), c.String("tls-key")
+	if (certFile == "") != (keyFile == "") {
+		return cli.NewExitError("Please set both of --tls-cert and --tls-key parameters", 1)
+	}
+
+	bundle, err := icepacker.OpenBundleLazy(c.Args()[0], icepacker.HashingKey(icepacker.NewCipherSettings(c.String("key"))))
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("%s", err), 3)
+	}
+	defer bundle.Close()
+
+	if size := c.Int("cache"); size > 0 {
+		bundle.Cache = icepacker.NewContentCache(int64(size) << 20)
+	}
+
+	handler := web.NewHandler(bundle)
+	handler.DirIndex = !c.Bool("no-index")
+	if c.Bool("spa") {
+		handler.Fallback = "index.html"
+	}
+
+	fmt.Printf("Serving %d files of %s on %s\n", bundle.FAT.Count, c.Args()[0], c.String("addr"))
+
+	if certFile != "" {
+		err = http.ListenAndServeTLS(c.String("addr"), certFile, keyFile, handler)
+	} else {
+		err = http.ListenAndServe(c.String("addr"), handler)
+	}
+	return cli.NewExitError(fmt.Sprintf("%s", err), 3)
+}
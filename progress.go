@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/icebob/icepacker/lib"
+)
+
+// Progress is an icepacker.Listener which prints the progress of packing
+// or unpacking. On a terminal the progress line is refreshed in place,
+// otherwise a new line is printed periodically (e.g. in CI logs).
+type Progress struct {
+	Message  string
+	Out      io.Writer
+	Terminal bool
+	Interval time.Duration
+
+	state     icepacker.FileState
+	start     time.Time
+	lastPrint time.Time
+	lastLen   int
+}
+
+// NewProgress creates a new Progress which prints to the STDOUT
+func NewProgress(message string) *Progress {
+	terminal := IsTerminal(os.Stdout)
+	interval := 10 * time.Second
+	if terminal {
+		interval = 200 * time.Millisecond
+	}
+
+	return &Progress{
+		Message:  message,
+		Out:      os.Stdout,
+		Terminal: terminal,
+		Interval: interval,
+		start:    time.Now(),
+	}
+}
+
+// OnScanStart resets the start time
+func (this *Progress) OnScanStart(source string) {
+	this.start = time.Now()
+}
+
+// OnFileStart stores the state and prints the progress
+func (this *Progress) OnFileStart(state icepacker.FileState) {
+	this.state = state
+	this.print(false)
+}
+
+// OnFileProgress stores the state and prints the progress
+func (this *Progress) OnFileProgress(state icepacker.FileState) {
+	this.state = state
+	this.print(false)
+}
+
+// OnFileDone stores the state and prints the progress
+func (this *Progress) OnFileDone(state icepacker.FileState) {
+	this.state = state
+	this.print(false)
+}
+
+// OnError prints the error in a new line
+func (this *Progress) OnError(err error, filename string) {
+	if this.Terminal && this.lastLen > 0 {
+		fmt.Fprintln(this.Out)
+		this.lastLen = 0
+	}
+	fmt.Fprintf(this.Out, "ERROR: %s (file: %s)\n", err, filename)
+}
+
+// OnFinish prints the final state of the progress
+func (this *Progress) OnFinish(result icepacker.FinishResult) {
+	if result.Err == nil && this.state.Total > 0 {
+		this.print(true)
+	}
+	if this.Terminal && this.lastLen > 0 {
+		fmt.Fprintln(this.Out)
+	}
+}
+
+// print prints the progress line if the interval is elapsed since the last print
+func (this *Progress) print(force bool) {
+	now := time.Now()
+	if !force && now.Sub(this.lastPrint) < this.Interval {
+		return
+	}
+	this.lastPrint = now
+
+	line := this.Message + ": " + FormatProgress(this.state.DoneSize, this.state.TotalSize, now.Sub(this.start))
+
+	if this.Terminal {
+		padding := ""
+		if len(line) < this.lastLen {
+			padding = strings.Repeat(" ", this.lastLen-len(line))
+		}
+		fmt.Fprintf(this.Out, "%s%s%s", ClearLine(), line, padding)
+		this.lastLen = len(line)
+	} else {
+		fmt.Fprintf(this.Out, "%s %s\n", now.Format("15:04:05"), line)
+	}
+}
+
+// IsTerminal returns true if the file is a character device (terminal)
+func IsTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/urfave/cli"
+
+	"github.com/icebob/icepacker/lib"
+)
+
+// stubFileName returns the file name of the prebuilt stub of the platform
+func stubFileName(platform string) string {
+	name := "icepacker-stub-" + platform
+	if strings.HasPrefix(platform, "windows-") {
+		name += ".exe"
+	}
+	return name
+}
+
+// findStub returns the path of the stub executable. The name is the path of
+// a stub file, or a platform (`GOOS-GOARCH`). The prebuilt stub of the
+// platform is searched next to the icepacker executable.
+func findStub(name string) (string, error) {
+	if info, err := os.Stat(name); err == nil && !info.IsDir() {
+		return name, nil
+	}
+
+	parts := strings.Split(name, "-")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("Invalid stub: %s (use GOOS-GOARCH or the path of a stub file)", name)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	stub := filepath.Join(filepath.Dir(exe), stubFileName(name))
+	if _, err := os.Stat(stub); err != nil {
+		return "", fmt.Errorf("The stub of %s is not found! Expected: %s\n"+
+			"The release archive of the platform contains it. Otherwise build it with `GOOS=%s GOARCH=%s go build -ldflags \"-s -w\" -o %s github.com/icebob/icepacker/stub`, "+
+			"or set the path of a stub file with --stub", name, stub, parts[0], parts[1], stub)
+	}
+	return stub, nil
+}
+
+func sfx(c *cli.Context) error {
+	if len(c.Args()) != 2 {
+		cli.ShowCommandHelp(c, "sfx")
+		return cli.NewExitError("Please set package filename and output filename", 2)
+	}
+
+	stubPath, err := findStub(c.String("stub"))
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("%s", err), 3)
+	}
+
+	err = writeSFX(c.Args()[0], c.Args()[1], stubPath, icepacker.SFXConfig{
+		Dir:     c.String("dir"),
+		Command: strings.Fields(c.String("run")),
+	})
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("%s", err), 3)
+	}
+
+	info, err := os.Stat(c.Args()[1])
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("%s", err), 3)
+	}
+	fmt.Printf("Self-extracting executable: %s (%s)\n", c.Args()[1], FormatBytes(info.Size()))
+	return nil
+}
+
+// writeSFX writes the stub, the config & the bundle to the executable output file
+func writeSFX(packFile string, output string, stubPath string, config icepacker.SFXConfig) error {
+	packPath, _ := filepath.Abs(packFile)
+	outputPath, _ := filepath.Abs(output)
+	if packPath == outputPath {
+		return errors.New("The output file must be different from the package file!")
+	}
+
+	pack, err := os.Open(packFile)
+	if err != nil {
+		return err
+	}
+	defer pack.Close()
+
+	info, err := pack.Stat()
+	if err != nil {
+		return err
+	}
+
+	stub, err := os.Open(stubPath)
+	if err != nil {
+		return err
+	}
+	defer stub.Close()
+
+	out, err := os.OpenFile(output, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	if err != nil {
+		return err
+	}
+
+	err = icepacker.WriteSFX(out, stub, config, pack, info.Size())
+	if err != nil {
+		out.Close()
+		os.Remove(output)
+		return err
+	}
+	return out.Close()
+}
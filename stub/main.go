@@ -0,0 +1,164 @@
+// The stub of the self-extracting executables (see `icepacker sfx`). It
+// extracts the bundle appended to the executable and runs the entry command.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/icebob/icepacker/lib"
+)
+
+func main() {
+	dir := flag.String("dir", "", "Target directory (default: the directory of the SFX config or a temporary directory)")
+	key := flag.String("key", "", "Key for decrypting (or the ICEPACKER_KEY environment variable)")
+	noRun := flag.Bool("no-run", false, "Extract only, don't run the entry command")
+	flag.Parse()
+
+	code, err := run(*dir, *key, *noRun, flag.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(code)
+}
+
+// run extracts the bundle and runs the entry command with the arguments.
+// It returns the exit code of the command.
+func run(dir string, key string, noRun bool, args []string) (int, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+
+	f, err := os.Open(exe)
+	if err != nil {
+		return 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return 0, err
+	}
+	config, header, err := icepacker.ReadSFX(f, info.Size())
+	f.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	if key == "" {
+		key = os.Getenv("ICEPACKER_KEY")
+	}
+	if key == "" && header.Encrypt != icepacker.ENCRYPT_NONE {
+		key, err = promptKey()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// Extract into a temporary directory, which is removed after the command
+	temp := false
+	if dir == "" {
+		dir = config.Dir
+	}
+	if dir == "" {
+		dir, err = ioutil.TempDir("", "icepacker-sfx-")
+		if err != nil {
+			return 0, err
+		}
+		temp = true
+	}
+
+	listener := &errorListener{}
+	res := icepacker.Unpack(icepacker.UnpackSettings{
+		PackFileName: exe,
+		TargetDir:    dir,
+		Cipher:       icepacker.NewCipherSettings(key),
+		Listener:     listener,
+	})
+	if res.Err == nil && listener.errors > 0 {
+		res.Err = fmt.Errorf("The extraction failed! Errors: %d", listener.errors)
+	}
+	if res.Err != nil {
+		if temp {
+			os.RemoveAll(dir)
+		}
+		return 0, res.Err
+	}
+	fmt.Fprintf(os.Stderr, "Extracted %d files to %s\n", res.FileCount, dir)
+
+	if len(config.Command) == 0 || noRun {
+		return 0, nil
+	}
+	if temp {
+		defer os.RemoveAll(dir)
+	}
+
+	name := config.Command[0]
+	if !filepath.IsAbs(name) {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			name = filepath.Join(dir, name)
+		}
+	}
+
+	cmd := exec.Command(name, append(config.Command[1:], args...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "ICEPACKER_SFX_DIR="+dir)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+
+	err = cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status := exitErr.ExitCode(); status >= 0 {
+			return status, nil
+		}
+	}
+	return 0, err
+}
+
+// errorListener prints & counts the errors of the extraction
+type errorListener struct {
+	icepacker.NopListener
+	errors int
+}
+
+// OnError prints the error of the file
+func (this *errorListener) OnError(err error, filename string) {
+	fmt.Fprintf(os.Stderr, "ERROR: %s (file: %s)\n", err, filename)
+	this.errors++
+}
+
+// promptKey reads the key from the terminal (without echo if `stty` is available)
+func promptKey() (string, error) {
+	fmt.Fprint(os.Stderr, "Key: ")
+	if runtime.GOOS != "windows" && stty("-echo") == nil {
+		defer func() {
+			stty("echo")
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	key := strings.TrimRight(line, "\r\n")
+	if key == "" {
+		if err != nil {
+			return "", errors.New("The key is required to extract the encrypted bundle!")
+		}
+		return "", errors.New("The key is empty!")
+	}
+	return key, nil
+}
+
+// stty sets the mode of the terminal of STDIN
+func stty(mode string) error {
+	cmd := exec.Command("stty", mode)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
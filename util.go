@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"runtime"
 	"strings"
+	"time"
 )
 
 // ClearLine creates a platform dependent string to clear the current
@@ -17,9 +18,35 @@ func ClearLine() string {
 	return "\r"
 }
 
-// PrintProgress print the percentage of the progress to the STDOUT
-func PrintProgress(message string, pos int, count int) {
-	fmt.Printf("%s%s: %s", ClearLine(), message, FormatPercent(uint64(pos), uint64(count)))
+// FormatProgress formats the percentage, the processed bytes, the speed
+// and the estimated remaining time of the progress
+func FormatProgress(done int64, total int64, elapsed time.Duration) string {
+	res := fmt.Sprintf("%s / %s, %s, ETA %s", FormatBytes(done), FormatBytes(total), FormatSpeed(done, elapsed), FormatETA(done, total, elapsed))
+	if total > 0 {
+		res = FormatPercent(uint64(done), uint64(total)) + " " + res
+	}
+	return res
+}
+
+// FormatSpeed humanize the speed of processing
+func FormatSpeed(bytes int64, elapsed time.Duration) string {
+	if elapsed <= 0 {
+		return FormatBytes(0) + "/s"
+	}
+	return FormatBytes(int64(float64(bytes)/elapsed.Seconds())) + "/s"
+}
+
+// FormatETA calculate the estimated remaining time by the current speed
+func FormatETA(done int64, total int64, elapsed time.Duration) string {
+	if done <= 0 || elapsed <= 0 {
+		return "--:--"
+	}
+	if done >= total {
+		return FormatSeconds(0)
+	}
+
+	remaining := elapsed.Seconds() * float64(total-done) / float64(done)
+	return FormatSeconds(uint64(remaining + 0.5))
 }
 
 // FormatBytes humanize the length of the content
@@ -1,8 +1,14 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"runtime"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/icebob/icepacker/lib"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -111,6 +117,102 @@ func TestFormatPercent(t *testing.T) {
 
 }
 
+func TestFormatSpeed(t *testing.T) {
+
+	Convey("Test formatter", t, func() {
+
+		var tests = []struct {
+			bytes    int64
+			elapsed  time.Duration
+			expected string
+		}{
+			{0, 0, "0 B/s"},
+			{100, 0, "0 B/s"},
+			{100, time.Second, "100 B/s"},
+			{10 * 1024 * 1024, 2 * time.Second, "5.000 MiB/s"},
+			{1024, 500 * time.Millisecond, "2.000 KiB/s"},
+		}
+
+		for _, test := range tests {
+			So(FormatSpeed(test.bytes, test.elapsed), ShouldEqual, test.expected)
+		}
+
+	})
+
+}
+
+func TestFormatETA(t *testing.T) {
+
+	Convey("Test formatter", t, func() {
+
+		var tests = []struct {
+			done     int64
+			total    int64
+			elapsed  time.Duration
+			expected string
+		}{
+			{0, 100, time.Second, "--:--"},
+			{50, 100, 0, "--:--"},
+			{100, 100, time.Second, "0:00"},
+			{50, 100, 10 * time.Second, "0:10"},
+			{25, 100, 30 * time.Second, "1:30"},
+			{1, 3601, time.Second, "1:00:00"},
+		}
+
+		for _, test := range tests {
+			So(FormatETA(test.done, test.total, test.elapsed), ShouldEqual, test.expected)
+		}
+
+	})
+
+}
+
+func TestFormatProgress(t *testing.T) {
+
+	Convey("Test formatter", t, func() {
+
+		So(FormatProgress(512*1024, 1024*1024, 2*time.Second), ShouldEqual, " 50.00% [==========          ] 512.000 KiB / 1.000 MiB, 256.000 KiB/s, ETA 0:02")
+		So(FormatProgress(0, 0, time.Second), ShouldEqual, "0 B / 0 B, 0 B/s, ETA --:--")
+
+	})
+
+}
+
+func TestProgress(t *testing.T) {
+
+	Convey("Should print new lines if the output is not a terminal", t, func() {
+
+		out := new(bytes.Buffer)
+		progress := &Progress{Message: "Packing files", Out: out, Interval: time.Hour}
+
+		progress.OnScanStart("/src")
+		progress.OnFileStart(icepacker.FileState{Index: 0, Total: 2, Path: "big.sql", TotalSize: 1000})
+		progress.OnFileDone(icepacker.FileState{Index: 0, Total: 2, Path: "big.sql", TotalSize: 1000, DoneSize: 900})
+		progress.OnError(errors.New("failed"), "small.txt")
+		progress.OnFinish(icepacker.FinishResult{FileCount: 1})
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		So(lines, ShouldHaveLength, 3)
+		So(lines[0], ShouldContainSubstring, "Packing files:   0.00% [                    ] 0 B / 1000 B")
+		So(lines[1], ShouldEqual, "ERROR: failed (file: small.txt)")
+		So(lines[2], ShouldContainSubstring, "Packing files:  90.00% [==================  ] 900 B / 1000 B")
+	})
+
+	Convey("Should refresh the line on a terminal", t, func() {
+
+		out := new(bytes.Buffer)
+		progress := &Progress{Message: "Unpacking files", Out: out, Terminal: true}
+
+		progress.OnFileDone(icepacker.FileState{Index: 0, Total: 1, Path: "a.txt", TotalSize: 100, DoneSize: 100})
+		progress.OnFinish(icepacker.FinishResult{FileCount: 1})
+
+		So(out.String(), ShouldStartWith, ClearLine()+"Unpacking files: 100.00% [====================] 100 B / 100 B")
+		So(strings.Count(out.String(), ClearLine()), ShouldEqual, 2)
+		So(out.String(), ShouldEndWith, "\n")
+	})
+
+}
+
 func TestRound(t *testing.T) {
 
 	Convey("Test positive values", t, func() {